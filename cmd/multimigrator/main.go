@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/alexrjones/multimigrator/internal"
 	"github.com/alexrjones/multimigrator/multimigrator"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
 )
@@ -17,16 +29,109 @@ import (
 func main() {
 
 	upFlags := flag.NewFlagSet("up", flag.ExitOnError)
+	addLogFlags(upFlags)
+	addVarFlags(upFlags)
 	migrationsUp := upFlags.String("migrations", "", "Path to migrations directory")
-	connStr := upFlags.String("connStr", "", "Connection string for target database")
+	connStr := upFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
 	level := upFlags.String("level", "", "Target schema level to migrate to")
+	dryRun := upFlags.Bool("dry-run", false, "Print the migrations that would run instead of running them")
+	runUp := addRunFlags(upFlags)
+	allowDriftUp := upFlags.Bool("allow-drift", false, "Warn instead of failing when applied migrations have changed on disk")
+	outOfOrderUp := upFlags.Bool("allow-out-of-order", false, "Apply unapplied migrations older than a schema's applied version instead of failing")
+	transactionsUp := upFlags.String("transactions", "", "Run the migrations in one transaction per run or per version, so that a failure rolls them back: run or version")
+
+	downFlags := flag.NewFlagSet("down", flag.ExitOnError)
+	addLogFlags(downFlags)
+	addVarFlags(downFlags)
+	migrationsDown := downFlags.String("migrations", "", "Path to migrations directory")
+	connStrDown := downFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	levelDown := downFlags.String("level", "", "Target schema level to revert to; schemata after it are rolled back")
+	all := downFlags.Bool("all", false, "Revert the migrations of every schema")
+	steps := downFlags.Int("steps", 0, "Number of migrations to revert across all schemata, instead of a level")
+	runDown := addRunFlags(downFlags)
+
+	gotoFlags := flag.NewFlagSet("goto", flag.ExitOnError)
+	addLogFlags(gotoFlags)
+	addVarFlags(gotoFlags)
+	migrationsGoto := gotoFlags.String("migrations", "", "Path to migrations directory")
+	connStrGoto := gotoFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	levelGoto := gotoFlags.String("level", "", "Target schema level to migrate to")
+	version := gotoFlags.Uint("version", 0, "Target version; each schema is moved to its highest version at or below it, and 0 reverts every migration")
+	runGoto := addRunFlags(gotoFlags)
+	allowDriftGoto := gotoFlags.Bool("allow-drift", false, "Warn instead of failing when applied migrations have changed on disk")
+
+	planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+	addLogFlags(planFlags)
+	addVarFlags(planFlags)
+	migrationsPlan := planFlags.String("migrations", "", "Path to migrations directory")
+	connStrPlan := planFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	levelPlan := planFlags.String("level", "", "Target schema level to plan a migration to")
+	jsonPlan := planFlags.Bool("json", false, "Print the plan as JSON instead of a table")
+	outOfOrderPlan := planFlags.Bool("allow-out-of-order", false, "Plan unapplied migrations older than a schema's applied version instead of failing")
+
+	statusFlags := flag.NewFlagSet("status", flag.ExitOnError)
+	addLogFlags(statusFlags)
+	addVarFlags(statusFlags)
+	migrationsStatus := statusFlags.String("migrations", "", "Path to migrations directory")
+	connStrStatus := statusFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	jsonStatus := statusFlags.Bool("json", false, "Print the status as JSON instead of a table")
+
+	forceFlags := flag.NewFlagSet("force", flag.ExitOnError)
+	addLogFlags(forceFlags)
+	addVarFlags(forceFlags)
+	migrationsForce := forceFlags.String("migrations", "", "Path to migrations directory")
+	connStrForce := forceFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	schemaForce := forceFlags.String("schema", "", "Schema to force the version of")
+	versionForce := forceFlags.Int("version", 0, "Version to force the schema to; -1 marks it as having no migrations applied")
+	runForce := addRunFlags(forceFlags)
+
+	baselineFlags := flag.NewFlagSet("baseline", flag.ExitOnError)
+	addLogFlags(baselineFlags)
+	addVarFlags(baselineFlags)
+	migrationsBaseline := baselineFlags.String("migrations", "", "Path to migrations directory")
+	connStrBaseline := baselineFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	schemaBaseline := baselineFlags.String("schema", "", "Schema to baseline")
+	versionBaseline := baselineFlags.Uint("version", 0, "Version to mark the schema at, without running any migrations")
+	allAt := baselineFlags.Uint("all-at", 0, "Baseline every schema at its highest version at or below this one, instead of -schema and -version")
+	runBaseline := addRunFlags(baselineFlags)
+
+	repairFlags := flag.NewFlagSet("repair", flag.ExitOnError)
+	addLogFlags(repairFlags)
+	addVarFlags(repairFlags)
+	migrationsRepair := repairFlags.String("migrations", "", "Path to migrations directory")
+	connStrRepair := repairFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	jsonRepair := repairFlags.Bool("json", false, "Print the dirty schemata as JSON instead of a table")
+
+	validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	addVarFlags(validateFlags)
+	migrationsValidate := validateFlags.String("migrations", "", "Path to migrations directory")
+	jsonValidate := validateFlags.Bool("json", false, "Print the issues as JSON")
+
+	historyFlags := flag.NewFlagSet("history", flag.ExitOnError)
+	addLogFlags(historyFlags)
+	addVarFlags(historyFlags)
+	migrationsHistory := historyFlags.String("migrations", "", "Path to migrations directory")
+	connStrHistory := historyFlags.String("connStr", "", "Connection string for target database (postgres://, pgx5://, mysql:// or sqlite://)")
+	schemaHistory := historyFlags.String("schema", "", "Only show the history of this schema")
+	limitHistory := historyFlags.Int("limit", 50, "Maximum number of entries to show, or 0 for all")
+	jsonHistory := historyFlags.Bool("json", false, "Print the history as JSON instead of a table")
 
 	codegenFlags := flag.NewFlagSet("codegen", flag.ExitOnError)
 	migrationsCodegen := codegenFlags.String("migrations", "", "Path to migrations directory")
 	packageName := codegenFlags.String("package", "migrationlevel", "Output package name")
 
+	newFlags := flag.NewFlagSet("new", flag.ExitOnError)
+	migrationsNew := newFlags.String("migrations", "", "Path to migrations directory")
+	schemaNew := newFlags.String("schema", "", "Schema to create the migration for")
+	nameNew := newFlags.String("name", "", "Name of the migration, such as AddInvoices")
+	versionsNew := newFlags.String("versions", string(multimigrator.SequentialVersions), "How to number the migration: sequential or timestamp")
+
 	flag.Parse()
 
+	// Cancel running migrations between steps on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if len(os.Args) < 2 {
 		log.Fatalf("No subcommand provided, invocation was: %v", os.Args)
 	}
@@ -37,7 +142,135 @@ func main() {
 			if err != nil {
 				log.Fatalf("%v", err)
 			}
-			err = migrate(*migrationsUp, *connStr, *level)
+			if *dryRun {
+				err = plan(ctx, *migrationsUp, *connStr, *level, *outOfOrderUp, false)
+			} else {
+				err = migrate(ctx, *migrationsUp, *connStr, *level, runUp, *allowDriftUp, *outOfOrderUp, *transactionsUp)
+			}
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "down":
+		{
+			err := downFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = rollback(ctx, *migrationsDown, *connStrDown, *levelDown, *all, *steps, runDown)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "goto":
+		{
+			err := gotoFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if !isFlagSet(gotoFlags, "version") {
+				log.Fatalf("no target version provided")
+			}
+			err = migrateTo(ctx, *migrationsGoto, *connStrGoto, *levelGoto, *version, runGoto, *allowDriftGoto)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "plan":
+		{
+			err := planFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = plan(ctx, *migrationsPlan, *connStrPlan, *levelPlan, *outOfOrderPlan, *jsonPlan)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "status":
+		{
+			err := statusFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = status(ctx, *migrationsStatus, *connStrStatus, *jsonStatus)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "force":
+		{
+			err := forceFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if !isFlagSet(forceFlags, "version") {
+				log.Fatalf("no version provided")
+			}
+			err = force(ctx, *migrationsForce, *connStrForce, *schemaForce, *versionForce, runForce)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "baseline":
+		{
+			err := baselineFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if isFlagSet(baselineFlags, "all-at") {
+				if *schemaBaseline != "" || isFlagSet(baselineFlags, "version") {
+					log.Fatalf("-all-at can't be used with -schema or -version")
+				}
+				err = baselineAll(ctx, *migrationsBaseline, *connStrBaseline, *allAt, runBaseline)
+			} else {
+				if !isFlagSet(baselineFlags, "version") {
+					log.Fatalf("no version provided")
+				}
+				err = baseline(ctx, *migrationsBaseline, *connStrBaseline, *schemaBaseline, *versionBaseline, runBaseline)
+			}
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "repair":
+		{
+			err := repairFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = repair(ctx, *migrationsRepair, *connStrRepair, *jsonRepair)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "validate":
+		{
+			err := validateFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = validate(*migrationsValidate, *jsonValidate)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	case "history":
+		{
+			err := historyFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = history(ctx, *migrationsHistory, *connStrHistory, *schemaHistory, *limitHistory, *jsonHistory)
 			if err != nil {
 				log.Fatalf("%v", err)
 			}
@@ -55,34 +288,452 @@ func main() {
 			}
 			return
 		}
+	case "new":
+		{
+			err := newFlags.Parse(os.Args[2:])
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			err = newMigration(*migrationsNew, *schemaNew, *nameNew, *versionsNew)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
 	}
 	log.Fatalf("Invalid subcommand name %s", os.Args[1])
 }
 
-func migrate(migrationsDir, connStr, target string) error {
-	if migrationsDir == "" {
-		return errors.New("no migrations directory provided")
+func migrate(ctx context.Context, migrationsDir, connStr, target string, rf *runFlags, allowDrift, allowOutOfOrder bool, transactions string) error {
+	if target == "" {
+		return errors.New("no target level provided")
 	}
-	if connStr == "" {
-		return errors.New("no connection string provided")
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	migrator.AllowDrift = allowDrift
+	migrator.AllowOutOfOrder = allowOutOfOrder
+	migrator.Transactions = multimigrator.TransactionMode(transactions)
+	return migrator.UpContext(ctx, target, db)
+}
+
+func rollback(ctx context.Context, migrationsDir, connStr, target string, all bool, steps int, rf *runFlags) error {
+	if target == "" && !all && steps == 0 {
+		return errors.New("one of a target level, -all or -steps must be provided")
+	}
+	if steps != 0 && (target != "" || all) {
+		return errors.New("-steps can't be combined with a target level or -all")
+	}
+	if target != "" && all {
+		return errors.New("-all can't be combined with a target level")
+	}
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	if steps != 0 {
+		return migrator.DownStepsContext(ctx, steps, db)
 	}
+	return migrator.DownContext(ctx, target, db)
+}
+
+func migrateTo(ctx context.Context, migrationsDir, connStr, target string, version uint, rf *runFlags, allowDrift bool) error {
 	if target == "" {
 		return errors.New("no target level provided")
 	}
-	result, err := internal.ParseMigrationsDirectory(migrationsDir)
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	migrator.AllowDrift = allowDrift
+	return migrator.GotoContext(ctx, target, version, db)
+}
+
+func plan(ctx context.Context, migrationsDir, connStr, target string, allowOutOfOrder, asJSON bool) error {
+	if target == "" {
+		return errors.New("no target level provided")
+	}
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	migrator.AllowOutOfOrder = allowOutOfOrder
+	planned, err := migrator.PlanContext(ctx, target, db)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(planned)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCHEMA\tVERSION\tIDENTIFIER\tFILE\tNOTE")
+	for _, p := range planned {
+		note := ""
+		if p.OutOfOrder {
+			note = "out of order"
+		} else if p.Repeatable {
+			note = "repeatable"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", p.Schema, p.Version, p.Identifier, orDash(p.Path), note)
+	}
+	return w.Flush()
+}
+
+func status(ctx context.Context, migrationsDir, connStr string, asJSON bool) error {
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	statuses, err := migrator.StatusContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(statuses)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCHEMA\tVERSION\tDIRTY\tPENDING\tMISSING\tDRIFTED")
+	for _, st := range statuses {
+		version := "-"
+		if st.Applied {
+			version = strconv.FormatUint(uint64(st.Version), 10)
+		}
+		drifted := "-"
+		if len(st.Drifted) > 0 {
+			drifted = strings.Join(st.Drifted, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\t%s\n", st.Schema, version, st.Dirty, formatVersions(st.Pending), formatVersions(st.Missing), drifted)
+	}
+	return w.Flush()
+}
+
+func force(ctx context.Context, migrationsDir, connStr, schema string, version int, rf *runFlags) error {
+	if schema == "" {
+		return errors.New("no schema provided")
+	}
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	return migrator.ForceContext(ctx, schema, version, db)
+}
+
+func baseline(ctx context.Context, migrationsDir, connStr, schema string, version uint, rf *runFlags) error {
+	if schema == "" {
+		return errors.New("no schema provided")
+	}
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	return migrator.BaselineContext(ctx, schema, version, db)
+}
+
+func baselineAll(ctx context.Context, migrationsDir, connStr string, version uint, rf *runFlags) error {
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf.apply(migrator)
+	return migrator.BaselineAllContext(ctx, version, db)
+}
+
+func repair(ctx context.Context, migrationsDir, connStr string, asJSON bool) error {
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	dirty, err := migrator.DirtyContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(dirty)
+	}
+	if len(dirty) == 0 {
+		fmt.Println("No schemata are dirty")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCHEMA\tVERSION\tUP FILE\tDOWN FILE")
+	for _, d := range dirty {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", d.Schema, d.Version, orDash(d.UpPath), orDash(d.DownPath))
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("Up file ran if an up migration failed; down file ran if a down migration failed.")
+	fmt.Println("After fixing the database by hand, run: multimigrator force -schema <schema> -version <version>")
+	return nil
+}
+
+func history(ctx context.Context, migrationsDir, connStr, schema string, limit int, asJSON bool) error {
+	migrator, db, err := openMigrator(migrationsDir, connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	entries, err := migrator.HistoryContext(ctx, db, multimigrator.HistoryFilter{Schema: schema, Limit: limit})
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tSCHEMA\tVERSION\tIDENTIFIER\tDIRECTION\tDURATION\tRESULT\tHOST")
+	for _, e := range entries {
+		result := "ok"
+		if !e.Success {
+			result = "failed: " + e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", e.StartedAt.Format(time.RFC3339), e.Schema, e.Version, e.Identifier, e.Direction, e.Duration, result, e.Hostname)
+	}
+	return w.Flush()
+}
+
+// logFlags holds the logging flags shared by the subcommands that open a
+// migrator. Only one subcommand's flags are parsed, so they can share it.
+var logFlags struct {
+	format  string
+	verbose bool
+}
+
+func addLogFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logFlags.format, "log-format", "text", "Log format, text or json")
+	fs.BoolVar(&logFlags.verbose, "v", false, "Also log golang-migrate's progress messages")
+}
+
+func newLogger() (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if logFlags.verbose {
+		opts.Level = slog.LevelDebug
+	}
+	switch logFlags.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", logFlags.format)
+	}
+}
+
+// templateVars holds the -var flags of the subcommands that load migrations.
+var templateVars = varFlag{}
+
+func addVarFlags(fs *flag.FlagSet) {
+	fs.Var(templateVars, "var", "Template variable as name=value, overriding order.yaml and the environment; may be repeated")
+}
+
+// varFlag is a flag.Value that collects name=value pairs.
+type varFlag map[string]string
+
+func (v varFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid variable %q, expected name=value", s)
+	}
+	v[name] = value
+	return nil
+}
+
+// runFlags are the flags shared by the subcommands that change the database.
+type runFlags struct {
+	lockTimeout      *time.Duration
+	statementTimeout *time.Duration
+	recordHistory    *bool
+}
+
+func addRunFlags(fs *flag.FlagSet) *runFlags {
+	return &runFlags{
+		lockTimeout:      fs.Duration("lock-timeout", multimigrator.DefaultLockTimeout, "How long to wait for another run to release its lock"),
+		statementTimeout: fs.Duration("statement-timeout", 0, "How long each migration may run, or 0 for no limit"),
+		recordHistory:    fs.Bool("history", false, "Record each migration step in the "+multimigrator.HistoryTable+" table"),
+	}
+}
+
+func (rf *runFlags) apply(migrator *multimigrator.Migrator) {
+	migrator.LockTimeout = *rf.lockTimeout
+	migrator.StatementTimeout = *rf.statementTimeout
+	migrator.RecordHistory = *rf.recordHistory
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatVersions(versions []uint) string {
+	if len(versions) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(versions))
+	for i, v := range versions {
+		parts[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func validate(migrationsDir string, asJSON bool) error {
+	migrator, err := loadMigrator(migrationsDir)
+	if err != nil {
+		return err
+	}
+	issues, err := migrator.Validate()
 	if err != nil {
 		return err
 	}
-	migrator, err := multimigrator.NewMigrator(migrationsDir, result.Ordering, true)
+	if asJSON {
+		err = printJSON(issues)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, vi := range issues {
+			fmt.Println(vi)
+		}
+	}
+	if multimigrator.HasErrors(issues) {
+		return errors.New("migrations directory has errors")
+	}
+	return nil
+}
+
+func loadMigrator(migrationsDir string, opts ...multimigrator.Option) (*multimigrator.Migrator, error) {
+	if migrationsDir == "" {
+		return nil, errors.New("no migrations directory provided")
+	}
+	logger, err := newLogger()
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]multimigrator.Option{
+		multimigrator.WithRootDir(migrationsDir),
+		multimigrator.WithSlogLogger(logger),
+		multimigrator.WithTemplateVars(templateVars),
+	}, opts...)
+	return multimigrator.New(opts...)
+}
+
+func openMigrator(migrationsDir, connStr string) (*multimigrator.Migrator, *sql.DB, error) {
+	if connStr == "" {
+		return nil, nil, errors.New("no connection string provided")
+	}
+	db, backend, err := openDatabase(connStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrator, err := loadMigrator(migrationsDir, multimigrator.WithBackend(backend))
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return migrator, db, nil
+}
+
+// openDatabase opens connStr with the driver and backend its scheme implies.
+// Strings without a scheme are Postgres keyword/value strings.
+func openDatabase(connStr string) (*sql.DB, multimigrator.Backend, error) {
+	scheme, rest, found := strings.Cut(connStr, "://")
+	if !found {
+		scheme = "postgres"
+	}
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		config, err := pgx.ParseConfig(connStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stdlib.OpenDB(*config), multimigrator.Postgres, nil
+	case "pgx", "pgx5":
+		config, err := pgx.ParseConfig("postgres://" + rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stdlib.OpenDB(*config), multimigrator.PgxV5, nil
+	case "mysql":
+		config, err := mysql.ParseDSN(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.MultiStatements = true
+		config.ParseTime = true
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sql.OpenDB(connector), multimigrator.MySQL, nil
+	case "sqlite", "sqlite3":
+		db, err := sql.Open("sqlite", rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, multimigrator.SQLite, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported connection string scheme %q", scheme)
+	}
+}
+
+func newMigration(migrationsDir, schema, name, versions string) error {
+	if schema == "" {
+		return errors.New("no schema provided")
+	}
+	migrator, err := loadMigrator(migrationsDir)
 	if err != nil {
 		return err
 	}
-	config, err := pgx.ParseConfig(connStr)
+	files, err := migrator.CreateMigration(schema, name, multimigrator.VersionScheme(versions))
 	if err != nil {
 		return err
 	}
-	db := stdlib.OpenDB(*config)
-	return migrator.Up(target, db)
+	fmt.Println(files.Up)
+	fmt.Println(files.Down)
+	return nil
 }
 
 func codegen(migrationsDir, packageName string) error {
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrDependencyCycle   = errors.New("schema dependencies contain a cycle")
+	ErrUnknownDependency = errors.New("dependency refers to an unknown schema")
+)
+
+// SortSchemata orders schemata so that every schema comes after the schemata it
+// depends on. The sort is stable: where the dependencies allow it, schemata keep
+// their order from the input, so an ordering that already respects the
+// dependencies is returned unchanged.
+func SortSchemata(schemata []string, dependsOn map[string][]string) ([]string, error) {
+
+	for s, deps := range dependsOn {
+		if !slices.Contains(schemata, s) {
+			return nil, fmt.Errorf("for schema %s: %w", s, ErrUnknownDependency)
+		}
+		for _, d := range deps {
+			if !slices.Contains(schemata, d) {
+				return nil, fmt.Errorf("for dependency %s of schema %s: %w", d, s, ErrUnknownDependency)
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(schemata))
+	placed := make(map[string]bool, len(schemata))
+	for len(ret) < len(schemata) {
+		progressed := false
+		for _, s := range schemata {
+			if placed[s] {
+				continue
+			}
+			ready := true
+			for _, d := range dependsOn[s] {
+				if !placed[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ret = append(ret, s)
+				placed[s] = true
+				progressed = true
+				// Restart from the beginning so earlier schemata keep priority
+				break
+			}
+		}
+		if !progressed {
+			remaining := make([]string, 0)
+			for _, s := range schemata {
+				if !placed[s] {
+					remaining = append(remaining, s)
+				}
+			}
+			return nil, fmt.Errorf("between schemata %s: %w", strings.Join(remaining, ", "), ErrDependencyCycle)
+		}
+	}
+
+	return ret, nil
+}
+
+// TransitiveDependencies returns schema and every schema it depends on, directly
+// or indirectly, in the order they appear in schemata.
+func TransitiveDependencies(schemata []string, dependsOn map[string][]string, schema string) []string {
+
+	needed := map[string]bool{schema: true}
+	queue := []string{schema}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, d := range dependsOn[s] {
+			if !needed[d] {
+				needed[d] = true
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(needed))
+	for _, s := range schemata {
+		if needed[s] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
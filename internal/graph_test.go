@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSortSchemata(t *testing.T) {
+
+	type testCase struct {
+		name      string
+		schemata  []string
+		dependsOn map[string][]string
+		expected  []string
+		err       error
+	}
+	tcs := []testCase{
+		{
+			name:      "Ordering that respects dependencies is unchanged",
+			schemata:  []string{"accounts", "analytics", "billing"},
+			dependsOn: map[string][]string{"billing": {"accounts"}},
+			expected:  []string{"accounts", "analytics", "billing"},
+		},
+		{
+			name:      "Schema listed before its dependency is moved after it",
+			schemata:  []string{"billing", "analytics", "accounts"},
+			dependsOn: map[string][]string{"billing": {"accounts"}},
+			expected:  []string{"analytics", "accounts", "billing"},
+		},
+		{
+			name:      "Cycles are rejected",
+			schemata:  []string{"a", "b", "c"},
+			dependsOn: map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			err:       ErrDependencyCycle,
+		},
+		{
+			name:      "Dependencies on unknown schemata are rejected",
+			schemata:  []string{"a", "b"},
+			dependsOn: map[string][]string{"b": {"z"}},
+			err:       ErrUnknownDependency,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			sorted, err := SortSchemata(tc.schemata, tc.dependsOn)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, sorted)
+		})
+	}
+}
+
+func TestTransitiveDependencies(t *testing.T) {
+
+	schemata := []string{"accounts", "analytics", "billing", "invoicing"}
+	dependsOn := map[string][]string{
+		"billing":   {"accounts"},
+		"invoicing": {"billing"},
+	}
+	assert.Equal(t, []string{"accounts", "billing", "invoicing"}, TransitiveDependencies(schemata, dependsOn, "invoicing"))
+	assert.Equal(t, []string{"analytics"}, TransitiveDependencies(schemata, dependsOn, "analytics"))
+}
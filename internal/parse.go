@@ -3,9 +3,10 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,10 +15,28 @@ import (
 // Find order.(yml|yaml) and read it
 // Generate an enum for the ordering
 
-var ErrNotDirectory = errors.New("provided path wasn't a directory")
+var (
+	ErrNotDirectory = errors.New("provided path wasn't a directory")
+	ErrNoOrderFile  = errors.New("no order.yaml found")
+	// ErrInvalidTableName is returned when a migrations table template doesn't
+	// contain SchemaPlaceholder, which would make every schema share a table.
+	ErrInvalidTableName = errors.New("migrations table name must contain " + SchemaPlaceholder)
+)
+
+// SchemaPlaceholder is replaced by the schema name in a migrations table
+// template.
+const SchemaPlaceholder = "{schema}"
 
 type DatabaseDescription struct {
 	Ordering []string `yaml:"schema_ordering"`
+	// DependsOn maps a schema to the schemata it depends on. When it's empty,
+	// every schema depends on all of the schemata before it in Ordering.
+	DependsOn map[string][]string `yaml:"depends_on"`
+	// MigrationsTable is a template for the name of each schema's migrations
+	// table, such as "{schema}.schema_migrations". Empty means the default.
+	MigrationsTable string `yaml:"migrations_table"`
+	// TemplateVars are the variables that .sql.tmpl files are rendered with.
+	TemplateVars map[string]string `yaml:"template_vars"`
 }
 
 var orderingRegex = regexp.MustCompile(`order\.ya?ml$`)
@@ -31,7 +50,15 @@ func ParseMigrationsDirectory(migrationsDir string) (*DatabaseDescription, error
 	if !stat.IsDir() {
 		return nil, fmt.Errorf("for path %s: %w", migrationsDir, ErrNotDirectory)
 	}
-	dir, err := os.ReadDir(migrationsDir)
+
+	return ParseMigrationsFS(os.DirFS(migrationsDir))
+}
+
+// ParseMigrationsFS is like ParseMigrationsDirectory, but reads the order.yaml
+// from the root of fsys.
+func ParseMigrationsFS(fsys fs.FS) (*DatabaseDescription, error) {
+
+	dir, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, fmt.Errorf("could not read dir: %w", err)
 	}
@@ -41,11 +68,12 @@ func ParseMigrationsDirectory(migrationsDir string) (*DatabaseDescription, error
 		if d.IsDir() || !orderingRegex.MatchString(d.Name()) {
 			continue
 		}
-		open, err := os.Open(filepath.Join(migrationsDir, d.Name()))
+		open, err := fsys.Open(d.Name())
 		if err != nil {
 			return nil, fmt.Errorf("could not open %s: %w", d.Name(), err)
 		}
 		err = yaml.NewDecoder(open).Decode(&dd)
+		open.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -53,7 +81,16 @@ func ParseMigrationsDirectory(migrationsDir string) (*DatabaseDescription, error
 		break
 	}
 	if !found {
-		return nil, errors.New("no order.yaml found")
+		return nil, ErrNoOrderFile
+	}
+	if dd.MigrationsTable != "" && !strings.Contains(dd.MigrationsTable, SchemaPlaceholder) {
+		return nil, fmt.Errorf("for migrations_table %q: %w", dd.MigrationsTable, ErrInvalidTableName)
+	}
+	if len(dd.DependsOn) > 0 {
+		dd.Ordering, err = SortSchemata(dd.Ordering, dd.DependsOn)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &dd, nil
@@ -0,0 +1,147 @@
+package schematadriver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// DirectivePrefix starts a directive in a comment at the top of a migration
+// file, as in "-- multimigrator:timeout=5m".
+const DirectivePrefix = "multimigrator:"
+
+var ErrInvalidDirective = errors.New("invalid migration directive")
+
+// Directives are the options a migration file sets in the comment lines
+// before its first statement.
+type Directives struct {
+	// NoTransaction runs the file outside any transaction ("no-transaction").
+	NoTransaction bool
+	// Timeout limits how long the file's statements may run ("timeout=5m").
+	Timeout time.Duration
+	// LockTimeout limits how long the file's statements may wait for a lock
+	// ("lock_timeout=2s").
+	LockTimeout time.Duration
+	// Requires are the schema versions that must be applied before the file
+	// runs ("requires=billing@12"). The directive may be repeated.
+	Requires []Requirement
+}
+
+// Requirement is a version that another schema must have applied.
+type Requirement struct {
+	Schema  string
+	Version uint
+}
+
+func (r Requirement) String() string {
+	return fmt.Sprintf("%s@%d", r.Schema, r.Version)
+}
+
+// ParseDirectives reads the directives from the comment lines at the top of a
+// migration file. Comments that don't start with DirectivePrefix are skipped.
+func ParseDirectives(r io.Reader) (Directives, error) {
+
+	var d Directives
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			break
+		}
+		directive, ok := strings.CutPrefix(strings.TrimSpace(comment), DirectivePrefix)
+		if !ok {
+			continue
+		}
+		err := d.parse(directive)
+		if err != nil {
+			return Directives{}, fmt.Errorf("%w %q: %w", ErrInvalidDirective, strings.TrimSpace(comment), err)
+		}
+	}
+	return d, scanner.Err()
+}
+
+func (d *Directives) parse(directive string) error {
+
+	name, value, hasValue := strings.Cut(directive, "=")
+	var err error
+	switch strings.TrimSpace(name) {
+	case "no-transaction":
+		if hasValue {
+			return errors.New("no-transaction takes no value")
+		}
+		d.NoTransaction = true
+	case "timeout":
+		d.Timeout, err = parsePositiveDuration(value)
+	case "lock_timeout":
+		d.LockTimeout, err = parsePositiveDuration(value)
+	case "requires":
+		schema, version, ok := strings.Cut(value, "@")
+		schema = strings.TrimSpace(schema)
+		if !ok || schema == "" {
+			return errors.New("requires must be like <schema>@<version>")
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(version), 10, 64)
+		if err != nil {
+			return err
+		}
+		d.Requires = append(d.Requires, Requirement{Schema: schema, Version: uint(v)})
+	default:
+		return errors.New("unknown directive")
+	}
+	return err
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
+
+// Directives returns the directives of the migration file for version in the
+// given direction. Migrations without a file, such as those added with Add,
+// and missing files have none.
+func (f *SchemataDriver) Directives(version uint, direction source.Direction) (Directives, error) {
+
+	m, err := f.Migration(version, direction)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Directives{}, nil
+		}
+		return Directives{}, err
+	}
+	if m.Raw == "" {
+		return Directives{}, nil
+	}
+	var r io.ReadCloser
+	if direction == source.Up {
+		r, _, err = f.ReadUp(version)
+	} else {
+		r, _, err = f.ReadDown(version)
+	}
+	if err != nil {
+		return Directives{}, err
+	}
+	defer r.Close()
+	d, err := ParseDirectives(r)
+	if err != nil {
+		return Directives{}, fmt.Errorf("in %s: %w", m.Raw, err)
+	}
+	return d, nil
+}
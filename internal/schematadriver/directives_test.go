@@ -0,0 +1,100 @@
+package schematadriver
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestParseDirectives(t *testing.T) {
+
+	type testCase struct {
+		name     string
+		body     string
+		expected Directives
+		err      bool
+	}
+	tcs := []testCase{
+		{
+			name: "Directives in the leading comments",
+			body: `-- Adds an index without locking the table
+-- multimigrator:no-transaction
+--multimigrator:timeout=5m
+-- multimigrator:lock_timeout = 2s
+-- multimigrator:requires=billing@12
+-- multimigrator:requires=accounts@3
+
+CREATE INDEX CONCURRENTLY i ON t (id);
+`,
+			expected: Directives{
+				NoTransaction: true,
+				Timeout:       5 * time.Minute,
+				LockTimeout:   2 * time.Second,
+				Requires:      []Requirement{{"billing", 12}, {"accounts", 3}},
+			},
+		},
+		{
+			name:     "Spaces around a requirement's parts",
+			body:     "-- multimigrator:requires = billing @ 3 \n",
+			expected: Directives{Requires: []Requirement{{"billing", 3}}},
+		},
+		{
+			name:     "Directives after the first statement are ignored",
+			body:     "CREATE TABLE t (id INTEGER);\n-- multimigrator:no-transaction\n",
+			expected: Directives{},
+		},
+		{
+			name: "Unknown directive",
+			body: "-- multimigrator:no_transaction\n",
+			err:  true,
+		},
+		{
+			name: "Invalid duration",
+			body: "-- multimigrator:timeout=-1s\n",
+			err:  true,
+		},
+		{
+			name: "Invalid requirement",
+			body: "-- multimigrator:requires=billing\n",
+			err:  true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := ParseDirectives(strings.NewReader(tc.body))
+			if tc.err {
+				assert.ErrorIs(t, err, ErrInvalidDirective)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func TestSchemataDriver_Directives(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_first_Start.up.sql":   {Data: []byte("-- multimigrator:timeout=1m\nCREATE SCHEMA first;\n")},
+		"0001_01_first_Start.down.sql": {Data: []byte("DROP SCHEMA first;\n")},
+	}
+	drv, err := WithFS(fsys, []string{"0001_01_first_Start.up.sql", "0001_01_first_Start.down.sql"})
+	assert.Nil(t, err)
+	driver := drv.(*SchemataDriver)
+	err = driver.Add(&source.Migration{Version: 2, Identifier: "backfill", Direction: source.Up})
+	assert.Nil(t, err)
+
+	d, err := driver.Directives(1, source.Up)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, d.Timeout)
+	d, err = driver.Directives(1, source.Down)
+	assert.Nil(t, err)
+	assert.Zero(t, d.Timeout)
+	d, err = driver.Directives(2, source.Up)
+	assert.Nil(t, err)
+	assert.Equal(t, Directives{}, d)
+}
@@ -1,7 +1,9 @@
 package schematadriver
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	nurl "net/url"
 	"os"
@@ -23,8 +25,11 @@ func init() {
 
 type SchemataDriver struct {
 	iofs.PartialDriver
-	url  string
-	path string
+	url        string
+	path       string
+	migrations *source.Migrations
+	// vars are the variables template files are rendered with
+	vars map[string]string
 }
 
 func (f *SchemataDriver) Open(url string) (source.Driver, error) {
@@ -32,20 +37,137 @@ func (f *SchemataDriver) Open(url string) (source.Driver, error) {
 	if err != nil {
 		return nil, err
 	}
+	fs, err := util.PathsFS(p, q)
+	if err != nil {
+		return nil, err
+	}
 	nf := &SchemataDriver{
 		url:  url,
 		path: p,
 	}
-	fs, err := util.PathsFS(p, q)
+	if err := nf.init(fs, q); err != nil {
+		return nil, err
+	}
+	return nf, nil
+}
+
+// WithFS returns a driver for the migration files named by paths in fsys, as
+// returned by ExpandPathsFS. Use it instead of Open when the migrations aren't
+// in a directory on disk, for example when they're embedded with go:embed.
+func WithFS(fsys fs.FS, paths []string) (source.Driver, error) {
+	pfs, err := util.NewPathsFS(fsys, paths)
 	if err != nil {
 		return nil, err
 	}
-	if err := nf.Init(fs, "."); err != nil {
+	nf := &SchemataDriver{
+		path: ".",
+	}
+	if err := nf.init(pfs, paths); err != nil {
 		return nil, err
 	}
 	return nf, nil
 }
 
+func (f *SchemataDriver) init(fsys fs.FS, paths []string) error {
+	if err := f.Init(fsys, "."); err != nil {
+		return err
+	}
+	// Keep our own index of the parsed files, since the PartialDriver's is
+	// private and we want to expose the file names to callers.
+	f.migrations = source.NewMigrations()
+	for _, name := range paths {
+		m, err := source.DefaultParse(name)
+		if err != nil {
+			continue
+		}
+		f.migrations.Append(m)
+	}
+	return nil
+}
+
+// ErrDuplicateVersion is returned by Add when the driver already has a migration
+// for the version and direction.
+var ErrDuplicateVersion = errors.New("migration version already exists")
+
+// Add adds a migration that has no file, such as one implemented in Go, so
+// that it takes part in the driver's ordering. Its Raw field must be empty,
+// and reading it returns an empty body.
+func (f *SchemataDriver) Add(m *source.Migration) error {
+	if m.Raw != "" {
+		return fmt.Errorf("migration %d has a file name %s", m.Version, m.Raw)
+	}
+	if !f.migrations.Append(m) {
+		return fmt.Errorf("for %s migration %d: %w", m.Direction, m.Version, ErrDuplicateVersion)
+	}
+	return nil
+}
+
+// First, Prev and Next use the driver's own index rather than the
+// PartialDriver's, so that they include migrations added with Add.
+
+func (f *SchemataDriver) First() (uint, error) {
+	if version, ok := f.migrations.First(); ok {
+		return version, nil
+	}
+	return 0, &fs.PathError{Op: "first", Path: f.path, Err: fs.ErrNotExist}
+}
+
+func (f *SchemataDriver) Prev(version uint) (uint, error) {
+	if prev, ok := f.migrations.Prev(version); ok {
+		return prev, nil
+	}
+	return 0, &fs.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: f.path, Err: fs.ErrNotExist}
+}
+
+func (f *SchemataDriver) Next(version uint) (uint, error) {
+	if next, ok := f.migrations.Next(version); ok {
+		return next, nil
+	}
+	return 0, &fs.PathError{Op: fmt.Sprintf("next for version %v", version), Path: f.path, Err: fs.ErrNotExist}
+}
+
+func (f *SchemataDriver) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := f.migrations.Up(version); ok && m.Raw == "" {
+		return io.NopCloser(strings.NewReader("")), m.Identifier, nil
+	} else if ok && IsTemplate(m.Raw) {
+		r, err := f.read(m)
+		return r, m.Identifier, err
+	}
+	return f.PartialDriver.ReadUp(version)
+}
+
+func (f *SchemataDriver) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := f.migrations.Down(version); ok && m.Raw == "" {
+		return io.NopCloser(strings.NewReader("")), m.Identifier, nil
+	} else if ok && IsTemplate(m.Raw) {
+		r, err := f.read(m)
+		return r, m.Identifier, err
+	}
+	return f.PartialDriver.ReadDown(version)
+}
+
+// Migration returns the parsed migration file for version in the given direction.
+// The Raw field of the result holds the file name relative to the driver's root.
+func (f *SchemataDriver) Migration(version uint, direction source.Direction) (*source.Migration, error) {
+
+	var m *source.Migration
+	var ok bool
+	switch direction {
+	case source.Up:
+		m, ok = f.migrations.Up(version)
+	case source.Down:
+		m, ok = f.migrations.Down(version)
+	}
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   fmt.Sprintf("read version %v", version),
+			Path: f.path,
+			Err:  fs.ErrNotExist,
+		}
+	}
+	return m, nil
+}
+
 func parseURL(url string) (string, []string, error) {
 	u, err := nurl.Parse(url)
 	if err != nil {
@@ -78,18 +200,48 @@ func parseURL(url string) (string, []string, error) {
 	return p, u.Query()["path"], nil
 }
 
-// This is a template for a regex that matches a path like (0001)_(01)_(Schema)_(Create).up.sql
-// where the bracketed parts are:
+// This is a template for a regex that matches a path like (0001)_(01)_(Schema)_(Create).up.sql,
+// or a template like (0001)_(01)_(Schema)_(Create).up.sql.tmpl, where the bracketed parts are:
 // - version number
 // - schema index
 // - schema name
 // - migration identifier
-var regexTemplate = "\\d+_\\d+_{{.SchemaName}}_[^.]+\\.(?:up|down)\\.sql"
+var regexTemplate = "\\d+_\\d+_{{.SchemaName}}_[^.]+\\.(?:up|down)\\.sql(?:\\.tmpl)?$"
 var tmpl = template.Must(template.New("regex_template").Parse(regexTemplate))
 
+// This is a template for a regex that matches a repeatable migration like
+// R_(01)_(Schema)_(Views).sql, or a template like R_(01)_(Schema)_(Views).sql.tmpl,
+// where the bracketed parts are:
+// - schema index
+// - schema name
+// - migration identifier
+// Only files at the root are matched, since repeatables are read from there.
+var repeatableRegexTemplate = "^R_\\d+_{{.SchemaName}}_[^./]+\\.sql(?:\\.tmpl)?$"
+var repeatableTmpl = template.Must(template.New("repeatable_regex_template").Parse(repeatableRegexTemplate))
+
+// ExpandPaths finds the migration files for each schema in rootDir.
 func ExpandPaths(rootDir string, schemata []string) (map[string][]string, error) {
 
-	rootDir = filepath.Clean(rootDir)
+	return ExpandPathsFS(os.DirFS(filepath.Clean(rootDir)), schemata)
+}
+
+// ExpandPathsFS is like ExpandPaths, but finds the migration files in fsys.
+func ExpandPathsFS(fsys fs.FS, schemata []string) (map[string][]string, error) {
+
+	return expandFS(fsys, schemata, tmpl)
+}
+
+// ExpandRepeatablesFS finds the repeatable migration files for each schema in
+// fsys, which are named like R_02_billing_Views.sql.
+func ExpandRepeatablesFS(fsys fs.FS, schemata []string) (map[string][]string, error) {
+
+	return expandFS(fsys, schemata, repeatableTmpl)
+}
+
+// expandFS finds the files in fsys whose paths match the regex that tmpl
+// produces for each schema.
+func expandFS(fsys fs.FS, schemata []string, tmpl *template.Template) (map[string][]string, error) {
+
 	type regexEntry struct {
 		re  *regexp.Regexp
 		sch string
@@ -121,9 +273,12 @@ func ExpandPaths(rootDir string, schemata []string) (map[string][]string, error)
 	// Walk the directory and match migration files to schemata
 	// The output is a map like:
 	// {"first": ["0001_01_first_Create.up.sql"], "second: ["0001_02_second_Create.up.sql"]}
-	err := filepath.Walk(rootDir, func(path string, info fs.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 
-		if info.IsDir() {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
 			return nil
 		}
 
@@ -133,8 +288,8 @@ func ExpandPaths(rootDir string, schemata []string) (map[string][]string, error)
 				if _, ok := ret[r.sch]; !ok {
 					ret[r.sch] = make([]string, 0)
 				}
-				// Use the file name, as the `path` variable is absolute
-				ret[r.sch] = append(ret[r.sch], info.Name())
+				// Use the file name, as the driver reads files from the root
+				ret[r.sch] = append(ret[r.sch], d.Name())
 				return nil
 			}
 		}
@@ -2,8 +2,11 @@ package schematadriver
 
 import (
 	"io"
+	"os"
 	"testing"
+	"testing/fstest"
 
+	"github.com/golang-migrate/migrate/v4/source"
 	assert "github.com/stretchr/testify/require"
 )
 
@@ -42,6 +45,21 @@ func TestSchemataDriver_Open(t *testing.T) {
 	assert.Equal(t, "DROP SCHEMA first;\n", string(bodyContentsTwo))
 }
 
+func TestSchemataDriver_Migration(t *testing.T) {
+
+	d := &SchemataDriver{}
+	driver, err := d.Open(testSchema1 + "?path=0001_01_first_Start.up.sql&path=0002_01_first_Amend.up.sql")
+	assert.Nil(t, err)
+
+	m, err := driver.(*SchemataDriver).Migration(2, source.Up)
+	assert.Nil(t, err)
+	assert.Equal(t, "01_first_Amend", m.Identifier)
+	assert.Equal(t, "0002_01_first_Amend.up.sql", m.Raw)
+
+	_, err = driver.(*SchemataDriver).Migration(2, source.Down)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestExpandPaths(t *testing.T) {
 
 	schemata := []string{"first", "second"}
@@ -61,3 +79,84 @@ func TestExpandPaths_MultipleMatches(t *testing.T) {
 	assert.Equal(t, []string{"001_100_abcd_Start.up.sql", "002_100_abcd_Amend.up.sql"}, paths["abcd"])
 	assert.Equal(t, []string{"001_200_abcde_Start.up.sql"}, paths["abcde"])
 }
+
+func TestExpandRepeatablesFS(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_billing_Start.up.sql":     {},
+		"R_01_billing_Views.sql":           {},
+		"R_01_billing_Grants.sql.tmpl":     {},
+		"R_02_billing_ext_Functions.sql":   {},
+		"R_01_billing_Views.sql.bak":       {},
+		"nested/R_02_billing_ext_Misc.sql": {},
+	}
+	paths, err := ExpandRepeatablesFS(fsys, []string{"billing", "billing_ext"})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"R_01_billing_Views.sql", "R_01_billing_Grants.sql.tmpl"}, paths["billing"])
+	assert.Equal(t, []string{"R_02_billing_ext_Functions.sql"}, paths["billing_ext"])
+
+	paths, err = ExpandPathsFS(fsys, []string{"billing", "billing_ext"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0001_01_billing_Start.up.sql"}, paths["billing"])
+}
+
+func TestWithFS(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"order.yaml":                   {Data: []byte("schema_ordering: [first]\n")},
+		"0001_01_first_Start.up.sql":   {Data: []byte("CREATE SCHEMA first;\n")},
+		"0001_01_first_Start.down.sql": {Data: []byte("DROP SCHEMA first;\n")},
+	}
+	paths, err := ExpandPathsFS(fsys, []string{"first"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0001_01_first_Start.down.sql", "0001_01_first_Start.up.sql"}, paths["first"])
+
+	driver, err := WithFS(fsys, paths["first"])
+	assert.Nil(t, err)
+	version, err := driver.First()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), version)
+	body, identifier, err := driver.ReadDown(version)
+	assert.Nil(t, err)
+	defer body.Close()
+	assert.Equal(t, "01_first_Start", identifier)
+	contents, err := io.ReadAll(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "DROP SCHEMA first;\n", string(contents))
+}
+
+func TestSchemataDriver_Add(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_first_Start.up.sql": {Data: []byte("CREATE SCHEMA first;\n")},
+		"0003_01_first_Index.up.sql": {Data: []byte("CREATE INDEX i ON first.t (id);\n")},
+	}
+	drv, err := WithFS(fsys, []string{"0001_01_first_Start.up.sql", "0003_01_first_Index.up.sql"})
+	assert.Nil(t, err)
+	driver := drv.(*SchemataDriver)
+	err = driver.Add(&source.Migration{Version: 2, Identifier: "backfill", Direction: source.Up})
+	assert.Nil(t, err)
+	err = driver.Add(&source.Migration{Version: 3, Identifier: "clash", Direction: source.Up})
+	assert.ErrorIs(t, err, ErrDuplicateVersion)
+
+	next, err := driver.Next(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), next)
+	next, err = driver.Next(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), next)
+	prev, err := driver.Prev(3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), prev)
+
+	body, identifier, err := driver.ReadUp(2)
+	assert.Nil(t, err)
+	defer body.Close()
+	assert.Equal(t, "backfill", identifier)
+	contents, err := io.ReadAll(body)
+	assert.Nil(t, err)
+	assert.Empty(t, contents)
+
+	_, err = driver.Next(3)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
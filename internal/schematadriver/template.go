@@ -0,0 +1,104 @@
+package schematadriver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// TemplateSuffix marks a migration file, such as 0001_01_first_Start.up.sql.tmpl,
+// that's rendered with text/template before it runs.
+const TemplateSuffix = ".tmpl"
+
+var ErrTemplate = errors.New("couldn't render migration template")
+
+// IsTemplate reports whether the migration file name is a template.
+func IsTemplate(name string) bool {
+	return strings.HasSuffix(name, TemplateSuffix)
+}
+
+// RenderTemplate renders the template body of the named file with vars,
+// which the template refers to like {{.role_name}}. Variables that aren't in
+// vars are an error.
+func RenderTemplate(name string, body []byte, vars map[string]string) ([]byte, error) {
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrTemplate, name, err)
+	}
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrTemplate, name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SetTemplateVars sets the variables that template files are rendered with.
+func (f *SchemataDriver) SetTemplateVars(vars map[string]string) {
+	f.vars = vars
+}
+
+// CheckTemplates renders every template file, so that a missing variable is
+// found before any migration runs.
+func (f *SchemataDriver) CheckTemplates() error {
+
+	version, ok := f.migrations.First()
+	for ok {
+		for _, direction := range []source.Direction{source.Up, source.Down} {
+			m, err := f.Migration(version, direction)
+			if err != nil || !IsTemplate(m.Raw) {
+				continue
+			}
+			r, err := f.read(m)
+			if err != nil {
+				return err
+			}
+			r.Close()
+		}
+		version, ok = f.migrations.Next(version)
+	}
+	return nil
+}
+
+// ReadUpRaw is like ReadUp, but returns a template's source rather than
+// rendering it.
+func (f *SchemataDriver) ReadUpRaw(version uint) (io.ReadCloser, string, error) {
+	if m, ok := f.migrations.Up(version); ok && m.Raw == "" {
+		return io.NopCloser(strings.NewReader("")), m.Identifier, nil
+	}
+	return f.PartialDriver.ReadUp(version)
+}
+
+// read opens the migration's file, rendering it if it's a template.
+func (f *SchemataDriver) read(m *source.Migration) (io.ReadCloser, error) {
+
+	var r io.ReadCloser
+	var err error
+	if m.Direction == source.Up {
+		r, _, err = f.PartialDriver.ReadUp(m.Version)
+	} else {
+		r, _, err = f.PartialDriver.ReadDown(m.Version)
+	}
+	if err != nil || !IsTemplate(m.Raw) {
+		return r, err
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := RenderTemplate(m.Raw, body, f.vars)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(rendered)), nil
+}
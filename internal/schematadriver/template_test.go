@@ -0,0 +1,81 @@
+package schematadriver
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+
+	type testCase struct {
+		name     string
+		body     string
+		vars     map[string]string
+		expected string
+		err      bool
+	}
+	tcs := []testCase{
+		{
+			name:     "Variables are substituted",
+			body:     "GRANT SELECT ON t TO {{.role_name}};",
+			vars:     map[string]string{"role_name": "reader"},
+			expected: "GRANT SELECT ON t TO reader;",
+		},
+		{
+			name: "Undefined variable",
+			body: "GRANT SELECT ON t TO {{.role_name}};",
+			err:  true,
+		},
+		{
+			name: "Invalid template",
+			body: "GRANT SELECT ON t TO {{.role_name;",
+			vars: map[string]string{"role_name": "reader"},
+			err:  true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := RenderTemplate("0001_01_first_Start.up.sql.tmpl", []byte(tc.body), tc.vars)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrTemplate)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestSchemataDriver_Templates(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_first_Start.up.sql.tmpl": {Data: []byte("CREATE SCHEMA {{.name}};\n")},
+		"0001_01_first_Start.down.sql":    {Data: []byte("DROP SCHEMA first;\n")},
+	}
+	drv, err := WithFS(fsys, []string{"0001_01_first_Start.up.sql.tmpl", "0001_01_first_Start.down.sql"})
+	assert.Nil(t, err)
+	driver := drv.(*SchemataDriver)
+	assert.ErrorIs(t, driver.CheckTemplates(), ErrTemplate)
+
+	driver.SetTemplateVars(map[string]string{"name": "first"})
+	assert.Nil(t, driver.CheckTemplates())
+	r, identifier, err := driver.ReadUp(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "01_first_Start", identifier)
+	body, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "CREATE SCHEMA first;\n", string(body))
+	r, _, err = driver.ReadUpRaw(1)
+	assert.Nil(t, err)
+	body, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "CREATE SCHEMA {{.name}};\n", string(body))
+
+	m, err := driver.Migration(1, source.Down)
+	assert.Nil(t, err)
+	assert.False(t, IsTemplate(m.Raw))
+}
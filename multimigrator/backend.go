@@ -0,0 +1,449 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	pgxv5 "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var (
+	ErrUnsupportedDatabase = errors.New("database handle not supported by backend")
+	ErrQualifiedTable      = errors.New("backend doesn't support schema-qualified migrations tables")
+)
+
+// Backend adapts a Migrator to a database engine. It opens the golang-migrate
+// driver for each schema, takes the lock held for a whole run, and describes
+// the SQL dialect used for the tables multimigrator maintains itself.
+type Backend interface {
+	// Open returns a driver for a schema configured by config. Closing the
+	// driver must not close db.
+	Open(ctx context.Context, db *sql.DB, config DriverConfig) (database.Driver, error)
+	// TableExists reports whether table exists.
+	TableExists(ctx context.Context, db *sql.DB, table string) (bool, error)
+	// LockKey derives the key of a lock identified by names, scoped to the
+	// database conn is connected to.
+	LockKey(ctx context.Context, conn *sql.Conn, names ...string) (string, error)
+	// TryLock takes the lock for key without waiting, reporting whether it
+	// was available. The lock is held by conn until Unlock is called.
+	TryLock(ctx context.Context, conn *sql.Conn, key string) (bool, error)
+	Unlock(ctx context.Context, conn *sql.Conn, key string) error
+	Dialect() Dialect
+}
+
+// DriverConfig configures the driver a Backend opens for a schema.
+type DriverConfig struct {
+	// Table records the schema's version. It may be qualified with the schema
+	// it's in.
+	Table string
+	// StatementTimeout limits how long each migration may run, on backends
+	// that support it. Zero means no limit.
+	StatementTimeout time.Duration
+}
+
+// Dialect describes the SQL differences between backends that matter to the
+// checksum and history tables.
+type Dialect struct {
+	// Placeholder returns the bind parameter for the nth argument, counting
+	// from 1.
+	Placeholder func(n int) string
+	// Text is the column type of short, indexed strings such as schema names.
+	Text string
+	// Timestamp is the column type of timestamps.
+	Timestamp string
+	// Serial is the definition of an auto-incrementing primary key column.
+	Serial string
+	// Quote quotes an identifier.
+	Quote func(name string) string
+	// TransactionalDDL is set if schema changes can be rolled back, which
+	// Up's transaction modes rely on.
+	TransactionalDDL bool
+	// Timeouts returns the statements that set and reset a session's
+	// statement and lock timeouts, where zero leaves a timeout as it is. It's
+	// nil if the backend doesn't support timeouts.
+	Timeouts func(statement, lock time.Duration) (set, reset string, err error)
+	// LocalTimeouts returns the statement that sets the statement and lock
+	// timeouts of the current transaction, where zero restores a timeout's
+	// default. It's nil if the backend can't limit a transaction's timeouts.
+	LocalTimeouts func(statement, lock time.Duration) string
+}
+
+var (
+	// Postgres migrates a PostgreSQL database through any database/sql driver.
+	Postgres Backend = postgresBackend{}
+	// PgxV5 migrates a PostgreSQL database opened with pgx/v5's stdlib
+	// package, using golang-migrate's pgx/v5 driver.
+	PgxV5 Backend = pgxV5Backend{}
+	// MySQL migrates a MySQL database. The connection must allow multiple
+	// statements, and must parse times to read the history table.
+	MySQL Backend = mysqlBackend{}
+	// SQLite migrates a SQLite database opened with modernc.org/sqlite. Its lock
+	// only excludes runs within the same process, and it has no statement
+	// timeout.
+	SQLite Backend = sqliteBackend{}
+)
+
+func (m *Migrator) backend() Backend {
+	if m.Backend == nil {
+		return Postgres
+	}
+	return m.Backend
+}
+
+func dollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func questionPlaceholder(int) string {
+	return "?"
+}
+
+func postgresTimeouts(statement, lock time.Duration) (string, string, error) {
+
+	var set, reset []string
+	if statement > 0 {
+		set = append(set, fmt.Sprintf("SET statement_timeout = %d", statement.Milliseconds()))
+		reset = append(reset, "RESET statement_timeout")
+	}
+	if lock > 0 {
+		set = append(set, fmt.Sprintf("SET lock_timeout = %d", lock.Milliseconds()))
+		reset = append(reset, "RESET lock_timeout")
+	}
+	return strings.Join(set, "; "), strings.Join(reset, "; "), nil
+}
+
+func postgresLocalTimeouts(statement, lock time.Duration) string {
+
+	set := func(name string, timeout time.Duration) string {
+		if timeout > 0 {
+			return fmt.Sprintf("SET LOCAL %s = %d", name, timeout.Milliseconds())
+		}
+		return "SET LOCAL " + name + " TO DEFAULT"
+	}
+	return set("statement_timeout", statement) + "; " + set("lock_timeout", lock)
+}
+
+// mysqlTimeouts only supports lock timeouts, since MySQL can only limit the
+// execution time of SELECT statements.
+func mysqlTimeouts(statement, lock time.Duration) (string, string, error) {
+
+	if statement > 0 {
+		return "", "", errors.New("MySQL doesn't support statement timeouts")
+	}
+	if lock <= 0 {
+		return "", "", nil
+	}
+	// MySQL's lock timeouts are in whole seconds
+	seconds := int64((lock + time.Second - 1) / time.Second)
+	set := fmt.Sprintf("SET SESSION lock_wait_timeout = %d, innodb_lock_wait_timeout = %d", seconds, seconds)
+	reset := "SET SESSION lock_wait_timeout = DEFAULT, innodb_lock_wait_timeout = DEFAULT"
+	return set, reset, nil
+}
+
+func doubleQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func backtickQuote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// splitTable splits a table name into the schema qualifying it, if any, and
+// the table's own name.
+func splitTable(table string) (string, string) {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return schema, name
+	}
+	return "", table
+}
+
+// quoteTable quotes each part of a possibly qualified table name.
+func quoteTable(dialect Dialect, table string) string {
+	schema, name := splitTable(table)
+	if schema == "" {
+		return dialect.Quote(name)
+	}
+	return dialect.Quote(schema) + "." + dialect.Quote(name)
+}
+
+type postgresBackend struct{}
+
+func (postgresBackend) Open(ctx context.Context, db *sql.DB, config DriverConfig) (database.Driver, error) {
+
+	// Use a connection rather than the *sql.DB itself, because closing a
+	// driver created with postgres.WithInstance would close the caller's db.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schema, name, err := postgresTable(ctx, conn, config.Table)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{
+		SchemaName:       schema,
+		MigrationsTable:  name,
+		StatementTimeout: config.StatementTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return driver, nil
+}
+
+// postgresTable splits table for a golang-migrate Postgres config, creating
+// the schema that qualifies it if it doesn't exist yet.
+func postgresTable(ctx context.Context, conn *sql.Conn, table string) (string, string, error) {
+
+	schema, name := splitTable(table)
+	if schema != "" {
+		_, err := conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+doubleQuote(schema))
+		if err != nil {
+			return "", "", fmt.Errorf("while creating schema %s: %w", schema, err)
+		}
+	}
+	return schema, name, nil
+}
+
+func (postgresBackend) TableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, quoteTable(Postgres.Dialect(), table)).Scan(&exists)
+	return exists, err
+}
+
+func (postgresBackend) LockKey(ctx context.Context, conn *sql.Conn, names ...string) (string, error) {
+
+	var databaseName string
+	err := conn.QueryRowContext(ctx, `SELECT CURRENT_DATABASE()`).Scan(&databaseName)
+	if err != nil {
+		return "", fmt.Errorf("while getting database name: %w", err)
+	}
+	return database.GenerateAdvisoryLockId(databaseName, names...)
+}
+
+func (postgresBackend) TryLock(ctx context.Context, conn *sql.Conn, key string) (bool, error) {
+
+	id, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, id).Scan(&locked)
+	return locked, err
+}
+
+func (postgresBackend) Unlock(ctx context.Context, conn *sql.Conn, key string) error {
+
+	id, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, id)
+	return err
+}
+
+func (postgresBackend) Dialect() Dialect {
+	return Dialect{
+		Placeholder:      dollarPlaceholder,
+		Text:             "TEXT",
+		Timestamp:        "TIMESTAMPTZ",
+		Serial:           "BIGSERIAL PRIMARY KEY",
+		Quote:            doubleQuote,
+		TransactionalDDL: true,
+		Timeouts:         postgresTimeouts,
+		LocalTimeouts:    postgresLocalTimeouts,
+	}
+}
+
+// pgxV5Backend shares its lock and dialect with postgresBackend.
+type pgxV5Backend struct {
+	postgresBackend
+}
+
+func (pgxV5Backend) Open(ctx context.Context, db *sql.DB, config DriverConfig) (database.Driver, error) {
+
+	// golang-migrate's pgx driver can only be created from a *sql.DB, which it
+	// closes along with itself, so it can't be given the caller's. Give it its
+	// own, using the caller's config, and bounded to the one connection the
+	// driver holds. Runs close the driver once they're done, and with it the
+	// pool.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	schema, name, err := postgresTable(ctx, conn, config.Table)
+	if err != nil {
+		return nil, err
+	}
+	var own *sql.DB
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("%w: pgx/v5 needs a database opened with pgx/v5/stdlib", ErrUnsupportedDatabase)
+		}
+		own = stdlib.OpenDB(*c.Conn().Config())
+		own.SetMaxOpenConns(1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	driver, err := pgxv5.WithInstance(own, &pgxv5.Config{
+		SchemaName:       schema,
+		MigrationsTable:  name,
+		StatementTimeout: config.StatementTimeout,
+	})
+	if err != nil {
+		own.Close()
+		return nil, err
+	}
+	return driver, nil
+}
+
+type mysqlBackend struct{}
+
+func (mysqlBackend) Open(ctx context.Context, db *sql.DB, config DriverConfig) (database.Driver, error) {
+
+	if schema, _ := splitTable(config.Table); schema != "" {
+		return nil, fmt.Errorf("for table %s: %w", config.Table, ErrQualifiedTable)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := mysql.WithConnection(ctx, conn, &mysql.Config{
+		MigrationsTable:  config.Table,
+		StatementTimeout: config.StatementTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return driver, nil
+}
+
+func (mysqlBackend) TableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+func (mysqlBackend) LockKey(ctx context.Context, conn *sql.Conn, names ...string) (string, error) {
+
+	var databaseName sql.NullString
+	err := conn.QueryRowContext(ctx, `SELECT DATABASE()`).Scan(&databaseName)
+	if err != nil {
+		return "", fmt.Errorf("while getting database name: %w", err)
+	}
+	return database.GenerateAdvisoryLockId(databaseName.String, names...)
+}
+
+func (mysqlBackend) TryLock(ctx context.Context, conn *sql.Conn, key string) (bool, error) {
+
+	var locked sql.NullBool
+	err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, key).Scan(&locked)
+	return locked.Bool, err
+}
+
+func (mysqlBackend) Unlock(ctx context.Context, conn *sql.Conn, key string) error {
+
+	_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, key)
+	return err
+}
+
+func (mysqlBackend) Dialect() Dialect {
+	return Dialect{
+		Placeholder: questionPlaceholder,
+		Text:        "VARCHAR(255)",
+		Timestamp:   "DATETIME(6)",
+		Serial:      "BIGINT AUTO_INCREMENT PRIMARY KEY",
+		Quote:       backtickQuote,
+		Timeouts:    mysqlTimeouts,
+	}
+}
+
+// sqliteLocks holds the keys of the SQLite locks taken in this process.
+var sqliteLocks sync.Map
+
+type sqliteBackend struct{}
+
+func (sqliteBackend) Open(ctx context.Context, db *sql.DB, config DriverConfig) (database.Driver, error) {
+
+	if schema, _ := splitTable(config.Table); schema != "" {
+		return nil, fmt.Errorf("for table %s: %w", config.Table, ErrQualifiedTable)
+	}
+	// The sqlite driver uses db directly rather than a connection from it, so
+	// just stop it from closing db.
+	driver, err := sqlite.WithInstance(db, &sqlite.Config{MigrationsTable: config.Table})
+	if err != nil {
+		return nil, err
+	}
+	return noCloseDriver{driver}, nil
+}
+
+func (sqliteBackend) TableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+// LockKey keys the lock by the database's file. In-memory databases have no
+// file, so they all share one lock.
+func (sqliteBackend) LockKey(ctx context.Context, conn *sql.Conn, names ...string) (string, error) {
+
+	var file string
+	err := conn.QueryRowContext(ctx, `SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&file)
+	if err != nil {
+		return "", fmt.Errorf("while getting database file: %w", err)
+	}
+	return file + "\x00" + strings.Join(names, "\x00"), nil
+}
+
+func (sqliteBackend) TryLock(_ context.Context, _ *sql.Conn, key string) (bool, error) {
+
+	_, held := sqliteLocks.LoadOrStore(key, struct{}{})
+	return !held, nil
+}
+
+func (sqliteBackend) Unlock(_ context.Context, _ *sql.Conn, key string) error {
+
+	sqliteLocks.Delete(key)
+	return nil
+}
+
+func (sqliteBackend) Dialect() Dialect {
+	return Dialect{
+		Placeholder:      questionPlaceholder,
+		Text:             "TEXT",
+		Timestamp:        "TIMESTAMP",
+		Serial:           "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Quote:            doubleQuote,
+		TransactionalDDL: true,
+	}
+}
+
+// noCloseDriver keeps a driver from closing the *sql.DB it was created with.
+type noCloseDriver struct {
+	database.Driver
+}
+
+func (noCloseDriver) Close() error {
+	return nil
+}
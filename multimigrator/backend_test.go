@@ -0,0 +1,129 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sqliteTables lists the tables in db.
+func sqliteTables(t *testing.T, db *sql.DB) []string {
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	assert.Nil(t, err)
+	defer rows.Close()
+	ret := make([]string, 0)
+	for rows.Next() {
+		var name string
+		assert.Nil(t, rows.Scan(&name))
+		ret = append(ret, name)
+	}
+	assert.Nil(t, rows.Err())
+	return ret
+}
+
+// testFS returns migrations with an order.yaml listing schemata, and a file
+// for each entry of files holding its contents.
+func testFS(schemata []string, files map[string]string) fstest.MapFS {
+
+	fsys := fstest.MapFS{
+		"order.yaml": {Data: []byte("schema_ordering: [" + strings.Join(schemata, ", ") + "]\n")},
+	}
+	for name, body := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return fsys
+}
+
+// sqliteMigrator returns a Migrator for the SQLite backend that reads fsys and
+// is configured by opts.
+func sqliteMigrator(t *testing.T, fsys fs.FS, opts ...Option) *Migrator {
+
+	m, err := New(append([]Option{WithFS(fsys), WithBackend(SQLite)}, opts...)...)
+	assert.Nil(t, err)
+	return m
+}
+
+func TestSQLite_UpDown(t *testing.T) {
+
+	fsys := testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":      "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_01_first_Start.down.sql":    "DROP TABLE first_items;\n",
+		"0001_02_second_Start.up.sql":     "CREATE TABLE second_items (id INTEGER);\n",
+		"0001_02_second_Start.down.sql":   "DROP TABLE second_items;\n",
+		"0002_01_first_AddName.up.sql":    "ALTER TABLE first_items ADD COLUMN name TEXT;\n",
+		"0002_01_first_AddName.down.sql":  "ALTER TABLE first_items DROP COLUMN name;\n",
+		"0002_02_second_AddName.up.sql":   "ALTER TABLE second_items ADD COLUMN name TEXT;\n",
+		"0002_02_second_AddName.down.sql": "ALTER TABLE second_items DROP COLUMN name;\n",
+	})
+	m := sqliteMigrator(t, fsys)
+	m.RecordHistory = true
+	db := openSQLite(t)
+
+	err := m.Up("second", db)
+	assert.Nil(t, err)
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	for _, s := range statuses {
+		assert.Equal(t, uint(2), s.Version)
+		assert.Empty(t, s.Pending)
+	}
+	_, err = db.Exec(`INSERT INTO second_items (id, name) VALUES (1, 'x')`)
+	assert.Nil(t, err)
+
+	entries, err := m.History(db, HistoryFilter{Schema: "first", Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint(2), entries[0].Version)
+
+	err = m.Down("", db)
+	assert.Nil(t, err)
+	statuses, err = m.Status(db)
+	assert.Nil(t, err)
+	for _, s := range statuses {
+		assert.False(t, s.Applied)
+	}
+	// The caller's db must still be usable
+	assert.Nil(t, db.Ping())
+}
+
+func TestSQLite_Lock(t *testing.T) {
+
+	m := &Migrator{Schemata: []string{"first"}, Backend: SQLite, LockTimeout: lockPollInterval}
+	ctx := context.Background()
+
+	// Databases in different files are locked separately
+	unlock, err := m.lock(ctx, openSQLite(t))
+	assert.Nil(t, err)
+	unlockOther, err := m.lock(ctx, openSQLite(t))
+	assert.Nil(t, err)
+	unlockOther()
+	unlock()
+
+	// In-memory databases have no file to tell them apart
+	memory := func() *sql.DB {
+		db, err := sql.Open("sqlite", ":memory:")
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	unlock, err = m.lock(ctx, memory())
+	assert.Nil(t, err)
+	_, err = m.lock(ctx, memory())
+	assert.ErrorIs(t, err, ErrLocked)
+	unlock()
+}
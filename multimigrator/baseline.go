@@ -0,0 +1,143 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+var (
+	ErrAlreadyBaselined = errors.New("schema already has a version applied")
+	ErrUnknownVersion   = errors.New("schema has no migration with that version")
+)
+
+// Baseline adopts a database that was created without multimigrator, by
+// creating the schema's migrations table and marking it at version without
+// running any migrations. Version must be one of the schema's migrations,
+// and the schema mustn't have a version applied already. Up then applies only
+// the migrations after it, and runs the schema's repeatable migrations.
+func (m *Migrator) Baseline(schema string, version uint, db *sql.DB) error {
+	return m.BaselineContext(context.Background(), schema, version, db)
+}
+
+// BaselineContext is like Baseline, but uses ctx while connecting to the
+// database.
+func (m *Migrator) BaselineContext(ctx context.Context, schema string, version uint, db *sql.DB) error {
+
+	index, ok := findSchema(schema, m.Schemata)
+	if !ok {
+		return fmt.Errorf("couldn't find schema %s: %w", schema, ErrNoSchema)
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, []int{index}, true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
+
+	p := migrators[0]
+	versions, err := p.versions()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(versions, version) {
+		return fmt.Errorf("%w: version %d of schema %s", ErrUnknownVersion, version, schema)
+	}
+	err = p.checkUnversioned()
+	if err != nil {
+		return err
+	}
+	return p.baseline(ctx, logger, version)
+}
+
+// BaselineAll is like Baseline for every schema, marking each at its highest
+// version at or below version. Schemata without such a version are left
+// without one. Nothing is marked if any schema already has a version applied.
+func (m *Migrator) BaselineAll(version uint, db *sql.DB) error {
+	return m.BaselineAllContext(context.Background(), version, db)
+}
+
+// BaselineAllContext is like BaselineAll, but uses ctx while connecting to the
+// database.
+func (m *Migrator) BaselineAllContext(ctx context.Context, version uint, db *sql.DB) error {
+
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, schemaRange(0, len(m.Schemata)), true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
+
+	for _, p := range migrators {
+		err = p.checkUnversioned()
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range migrators {
+		versions, err := p.versions()
+		if err != nil {
+			return err
+		}
+		// versions is in ascending order
+		i, found := slices.BinarySearch(versions, version)
+		if !found {
+			if i == 0 {
+				logger.Info("Schema has no migrations to baseline", "schema", p.schema, "version", version)
+				continue
+			}
+			i--
+		}
+		err = p.baseline(ctx, logger, versions[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkUnversioned fails with ErrAlreadyBaselined if the part has a version,
+// even a dirty one.
+func (p *migratorPart) checkUnversioned() error {
+
+	current, _, err := p.instance.Version()
+	if err == nil {
+		return fmt.Errorf("%w: schema %s is at version %d", ErrAlreadyBaselined, p.schema, current)
+	}
+	if !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	return nil
+}
+
+// baseline marks the part at version without running any migrations, and
+// records the checksums of the migrations up to it.
+func (p *migratorPart) baseline(ctx context.Context, logger *slog.Logger, version uint) error {
+
+	err := p.instance.Force(int(version))
+	if err != nil {
+		return fmt.Errorf("while baselining schema %s at version %d: %w", p.schema, version, err)
+	}
+	if p.checksums != nil {
+		err = p.recordUpTo(ctx, version)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("Baselined schema", "schema", p.schema, "version", version)
+	return nil
+}
@@ -0,0 +1,116 @@
+package multimigrator
+
+import (
+	"bytes"
+	"database/sql"
+	"log/slog"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestBaseline(t *testing.T) {
+
+	type testCase struct {
+		name     string
+		baseline func(m *Migrator, db *sql.DB) error
+		expected map[string]uint
+		err      error
+	}
+	tcs := []testCase{
+		{
+			name: "Single schema",
+			baseline: func(m *Migrator, db *sql.DB) error {
+				return m.Baseline("first", 2, db)
+			},
+			expected: map[string]uint{"first": 2},
+		},
+		{
+			name: "Unknown version",
+			baseline: func(m *Migrator, db *sql.DB) error {
+				return m.Baseline("first", 3, db)
+			},
+			err: ErrUnknownVersion,
+		},
+		{
+			name: "Unknown schema",
+			baseline: func(m *Migrator, db *sql.DB) error {
+				return m.Baseline("third", 1, db)
+			},
+			err: ErrNoSchema,
+		},
+		{
+			name: "Every schema at its highest version at or below N",
+			baseline: func(m *Migrator, db *sql.DB) error {
+				return m.BaselineAll(3, db)
+			},
+			expected: map[string]uint{"first": 2, "second": 3},
+		},
+		{
+			name: "Schemata without a version at or below N are skipped",
+			baseline: func(m *Migrator, db *sql.DB) error {
+				return m.BaselineAll(1, db)
+			},
+			expected: map[string]uint{"first": 1},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			// The tables already exist, so running the migrations would fail
+			m := sqliteMigrator(t, testFS([]string{"first", "second"}, map[string]string{
+				"0001_01_first_Start.up.sql":  "CREATE TABLE first (id INTEGER);\n",
+				"0002_01_first_Amend.up.sql":  "CREATE TABLE first_amend (id INTEGER);\n",
+				"0003_02_second_Start.up.sql": "CREATE TABLE second (id INTEGER);\n",
+				"0004_02_second_Amend.up.sql": "CREATE TABLE second_amend (id INTEGER);\n",
+				"0005_01_first_Later.up.sql":  "CREATE TABLE first_later (id INTEGER);\n",
+			}))
+			db := openSQLite(t)
+			for _, table := range []string{"first", "first_amend", "second"} {
+				_, err := db.Exec(`CREATE TABLE ` + table + ` (id INTEGER)`)
+				assert.Nil(t, err)
+			}
+
+			err := tc.baseline(m, db)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			statuses, err := m.Status(db)
+			assert.Nil(t, err)
+			versions := make(map[string]uint)
+			for _, st := range statuses {
+				if st.Applied {
+					versions[st.Schema] = st.Version
+				}
+			}
+			assert.Equal(t, tc.expected, versions)
+
+			// Baselining again fails, whichever schema it's for
+			assert.ErrorIs(t, m.BaselineAll(5, db), ErrAlreadyBaselined)
+		})
+	}
+}
+
+func TestBaseline_ThenUp(t *testing.T) {
+
+	var buf bytes.Buffer
+	m := sqliteMigrator(t, testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql": "CREATE TABLE first (id INTEGER);\n",
+		"0002_01_first_Amend.up.sql": "CREATE TABLE first_amend (id INTEGER);\n",
+		"0003_01_first_Later.up.sql": "ALTER TABLE first ADD COLUMN name TEXT;\n",
+	}), WithSlogLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	db := openSQLite(t)
+	for _, table := range []string{"first", "first_amend"} {
+		_, err := db.Exec(`CREATE TABLE ` + table + ` (id INTEGER)`)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, m.Baseline("first", 2, db))
+	assert.ErrorIs(t, m.Baseline("first", 2, db), ErrAlreadyBaselined)
+	assert.Nil(t, m.Up("first", db))
+	_, err := db.Exec(`INSERT INTO first (id, name) VALUES (1, 'a')`)
+	assert.Nil(t, err)
+	// The baselined versions count as applied, so they aren't warned about
+	assert.NotContains(t, buf.String(), "Can't tell whether")
+}
@@ -0,0 +1,273 @@
+package multimigrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// ChecksumTable is the table that records the checksum of every applied
+// migration file, shared by all schemata.
+const ChecksumTable = "multimigrator_checksums"
+
+var ErrChecksumMismatch = errors.New("applied migrations have changed on disk")
+
+// DriftedMigration is an applied migration whose file no longer matches the
+// checksum recorded when it was applied.
+type DriftedMigration struct {
+	Schema   string `json:"schema"`
+	Version  uint   `json:"version"`
+	File     string `json:"file"`
+	Recorded string `json:"recorded"`
+	Actual   string `json:"actual"`
+}
+
+type checksumStore interface {
+	checksums(ctx context.Context, schema string) (map[uint]string, error)
+	record(ctx context.Context, schema string, version uint, checksum string) error
+	forget(ctx context.Context, schema string, version uint) error
+}
+
+type sqlChecksumStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func newChecksumStore(ctx context.Context, db *sql.DB, dialect Dialect) (*sqlChecksumStore, error) {
+
+	query := `CREATE TABLE IF NOT EXISTS ` + ChecksumTable + ` (
+	schema_name ` + dialect.Text + ` NOT NULL,
+	version BIGINT NOT NULL,
+	checksum ` + dialect.Text + ` NOT NULL,
+	applied_at ` + dialect.Timestamp + ` NOT NULL,
+	PRIMARY KEY (schema_name, version)
+)`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, fmt.Errorf("while creating %s: %w", ChecksumTable, err)
+	}
+	return &sqlChecksumStore{db: db, dialect: dialect}, nil
+}
+
+// openChecksumStore returns the checksum store for a run. Runs that change the
+// database create its table; for the others a missing table means that no
+// checksums are recorded, and the store is nil.
+func (m *Migrator) openChecksumStore(ctx context.Context, db *sql.DB, write bool) (checksumStore, error) {
+
+	dialect := m.backend().Dialect()
+	if write {
+		return newChecksumStore(ctx, db, dialect)
+	}
+	exists, err := m.backend().TableExists(ctx, db, ChecksumTable)
+	if err != nil {
+		return nil, fmt.Errorf("while checking for %s: %w", ChecksumTable, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return &sqlChecksumStore{db: db, dialect: dialect}, nil
+}
+
+func (s *sqlChecksumStore) checksums(ctx context.Context, schema string) (map[uint]string, error) {
+
+	p := s.dialect.Placeholder
+	rows, err := s.db.QueryContext(ctx, `SELECT version, checksum FROM `+ChecksumTable+` WHERE schema_name = `+p(1), schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[uint]string)
+	for rows.Next() {
+		var version int64
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		ret[uint(version)] = checksum
+	}
+	return ret, rows.Err()
+}
+
+func (s *sqlChecksumStore) record(ctx context.Context, schema string, version uint, checksum string) error {
+
+	return replaceRow(ctx, s.db, s.dialect, ChecksumTable, 2, []string{"schema_name", "version", "checksum", "applied_at"},
+		schema, int64(version), checksum, time.Now().UTC())
+}
+
+// replaceRow inserts values into the columns of table, replacing any row with
+// the same values in the first keys columns.
+func replaceRow(ctx context.Context, db *sql.DB, dialect Dialect, table string, keys int, columns []string, values ...any) error {
+
+	// Replace any existing row in a transaction, since upserts aren't portable
+	p := dialect.Placeholder
+	where := make([]string, keys)
+	for i := range where {
+		where[i] = columns[i] + ` = ` + p(i+1)
+	}
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = p(i + 1)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+strings.Join(where, ` AND `), values[:keys]...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+table+` (`+strings.Join(columns, `, `)+`) VALUES (`+strings.Join(placeholders, `, `)+`)`, values...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlChecksumStore) forget(ctx context.Context, schema string, version uint) error {
+
+	p := s.dialect.Placeholder
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+ChecksumTable+` WHERE schema_name = `+p(1)+` AND version = `+p(2), schema, int64(version))
+	return err
+}
+
+// checksum returns the SHA-256 of the up migration file for version. A
+// template's source is checksummed rather than its rendered output, so that
+// changing a template variable doesn't count as drift.
+func (p *migratorPart) checksum(version uint) (string, error) {
+
+	r, _, err := p.sourceDrv.ReadUpRaw(version)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// recordUpTo records the checksums of the migrations up to version that have
+// none recorded, for a version that was marked applied without running them.
+// Checksums that are already recorded are kept, so that drift still shows.
+func (p *migratorPart) recordUpTo(ctx context.Context, version uint) error {
+
+	recorded, err := p.checksums.checksums(ctx, p.schema)
+	if err != nil {
+		return fmt.Errorf("while reading checksums for schema %s: %w", p.schema, err)
+	}
+	versions, err := p.versions()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v > version {
+			break
+		}
+		if _, ok := recorded[v]; ok {
+			continue
+		}
+		sum, err := p.checksum(v)
+		if err != nil {
+			return err
+		}
+		err = p.checksums.record(ctx, p.schema, v, sum)
+		if err != nil {
+			return fmt.Errorf("while recording checksum of version %d for schema %s: %w", v, p.schema, err)
+		}
+	}
+	return nil
+}
+
+// drift compares the recorded checksums of every applied migration with the
+// files on disk. Migrations applied before checksums were recorded, and
+// migrations whose files are missing, are skipped.
+func (mp migratorParts) drift(ctx context.Context) ([]DriftedMigration, error) {
+
+	ret := make([]DriftedMigration, 0)
+	for _, p := range mp {
+		if p.checksums == nil {
+			continue
+		}
+		applied, _, err := p.instance.Version()
+		if err != nil {
+			if errors.Is(err, migrate.ErrNilVersion) {
+				continue
+			}
+			return nil, err
+		}
+		recorded, err := p.checksums.checksums(ctx, p.schema)
+		if err != nil {
+			return nil, fmt.Errorf("while reading checksums for schema %s: %w", p.schema, err)
+		}
+		versions, err := p.versions()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if v > applied {
+				break
+			}
+			rec, ok := recorded[v]
+			if !ok {
+				continue
+			}
+			actual, err := p.checksum(v)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			if actual == rec {
+				continue
+			}
+			mig, err := p.sourceDrv.Migration(v, source.Up)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, DriftedMigration{
+				Schema:   p.schema,
+				Version:  v,
+				File:     mig.Raw,
+				Recorded: rec,
+				Actual:   actual,
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// checkDrift fails with ErrChecksumMismatch if any applied migration has
+// changed, or only logs the changes if allowDrift is set.
+func (mp migratorParts) checkDrift(ctx context.Context, logger *slog.Logger, allowDrift bool) error {
+
+	drifted, err := mp.drift(ctx)
+	if err != nil {
+		return err
+	}
+	if len(drifted) == 0 {
+		return nil
+	}
+	files := make([]string, len(drifted))
+	for i, d := range drifted {
+		files[i] = d.Schema + ": " + d.File
+	}
+	if allowDrift {
+		logger.Warn("Applied migrations have changed on disk", "files", files)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrChecksumMismatch, strings.Join(files, ", "))
+}
@@ -0,0 +1,99 @@
+package multimigrator
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type mockChecksumStore struct {
+	sums map[string]map[uint]string
+}
+
+func newMockChecksumStore() *mockChecksumStore {
+	return &mockChecksumStore{sums: make(map[string]map[uint]string)}
+}
+
+func (s *mockChecksumStore) checksums(ctx context.Context, schema string) (map[uint]string, error) {
+	return s.sums[schema], nil
+}
+
+func (s *mockChecksumStore) record(ctx context.Context, schema string, version uint, checksum string) error {
+	if _, ok := s.sums[schema]; !ok {
+		s.sums[schema] = make(map[uint]string)
+	}
+	s.sums[schema][version] = checksum
+	return nil
+}
+
+func (s *mockChecksumStore) forget(ctx context.Context, schema string, version uint) error {
+	delete(s.sums[schema], version)
+	return nil
+}
+
+func TestChecksums(t *testing.T) {
+
+	ctx := context.Background()
+	mp, _ := newMockMigratorParts([][]uint{{1, 2, 3}, {2, 3}})
+	store := newMockChecksumStore()
+	for _, p := range mp {
+		p.checksums = store
+	}
+
+	err := mp.applyMigrations(ctx, discardLogger(), math.MaxUint)
+	assert.Nil(t, err)
+	assert.Len(t, store.sums["schema0"], 3)
+	assert.Len(t, store.sums["schema1"], 2)
+	sum, err := mp[0].checksum(2)
+	assert.Nil(t, err)
+	assert.Equal(t, sum, store.sums["schema0"][2])
+
+	err = mp.revertMigrations(ctx, discardLogger(), 1, 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, store.sums["schema1"], uint(3))
+
+	drifted, err := mp.drift(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, drifted)
+	assert.Nil(t, mp.checkDrift(ctx, discardLogger(), false))
+
+	// Simulate editing the file for version 2 of the second schema after it was applied
+	store.sums["schema1"][2] = "edited"
+	drifted, err = mp.drift(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, drifted, 1)
+	assert.Equal(t, "schema1", drifted[0].Schema)
+	assert.Equal(t, uint(2), drifted[0].Version)
+	assert.Equal(t, "0002_02_schema1_Mock.up.sql", drifted[0].File)
+	assert.ErrorIs(t, mp.checkDrift(ctx, discardLogger(), false), ErrChecksumMismatch)
+	assert.Nil(t, mp.checkDrift(ctx, discardLogger(), true))
+}
+
+func TestChecksums_ReadOnly(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql": "CREATE TABLE first_items (id INTEGER);\n",
+	}))
+	db := openSQLite(t)
+	ctx := context.Background()
+
+	_, err := m.Status(db)
+	assert.Nil(t, err)
+	_, err = m.Plan("first", db)
+	assert.Nil(t, err)
+	_, err = m.Dirty(db)
+	assert.Nil(t, err)
+	exists, err := SQLite.TableExists(ctx, db, ChecksumTable)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, m.Up("first", db))
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.Empty(t, statuses[0].Drifted)
+	exists, err = SQLite.TableExists(ctx, db, ChecksumTable)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,149 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// Migration files can set these directives in comment lines before their
+// first statement:
+//
+//	-- multimigrator:no-transaction
+//	-- multimigrator:timeout=5m
+//	-- multimigrator:lock_timeout=2s
+//	-- multimigrator:requires=billing@12
+//
+// no-transaction runs the file outside the transaction of Up's transaction
+// modes. timeout and lock_timeout limit how long the file's statements may run
+// and wait for locks, on backends that support them. Migrations that run in a
+// transaction, such as Go migrations, need a backend that can limit them to
+// the transaction. requires fails an up migration with ErrRequirementNotMet
+// unless the schema has at least the given version applied, and may be
+// repeated.
+var (
+	ErrInvalidDirective     = schematadriver.ErrInvalidDirective
+	ErrRequirementNotMet    = errors.New("required schema version isn't applied")
+	ErrUnsupportedDirective = errors.New("directive isn't supported by the backend")
+)
+
+// querier runs queries on a database, connection or transaction.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withDirectives runs fn, which runs the migration for step, as the
+// directives of its file ask. Requirements are checked with q. If exec is set,
+// it runs statements in the session that fn runs the migration in. Otherwise
+// fn runs the migration in a transaction, and is passed the statement that
+// sets the transaction's timeouts, if any, to run first.
+func (p *migratorPart) withDirectives(ctx context.Context, q querier, exec func(query string) error, step StepInfo, fn func(timeouts string) error) error {
+
+	d, err := p.sourceDrv.Directives(step.Version, step.Direction)
+	if err != nil {
+		return err
+	}
+	if step.Direction == source.Up {
+		for _, r := range d.Requires {
+			err = p.checkRequirement(ctx, q, r)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if exec == nil {
+		if p.dialect.LocalTimeouts == nil && (d.Timeout != 0 || d.LockTimeout != 0) {
+			return fmt.Errorf("%w: timeouts for version %d of schema %s, which runs in a transaction", ErrUnsupportedDirective, step.Version, p.schema)
+		}
+		return fn(p.localTimeouts(d.Timeout, d.LockTimeout))
+	}
+	if d.Timeout == 0 && d.LockTimeout == 0 {
+		return fn("")
+	}
+	if p.dialect.Timeouts == nil {
+		return fmt.Errorf("%w: timeouts for version %d of schema %s", ErrUnsupportedDirective, step.Version, p.schema)
+	}
+	set, reset, err := p.dialect.Timeouts(d.Timeout, d.LockTimeout)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsupportedDirective, err)
+	}
+	err = exec(set)
+	if err != nil {
+		return fmt.Errorf("while setting timeouts: %w", err)
+	}
+	err = fn("")
+	if err != nil {
+		// The reset may fail too, for example in an aborted transaction
+		exec(reset)
+		return err
+	}
+	err = exec(reset)
+	if err != nil {
+		return fmt.Errorf("while resetting timeouts: %w", err)
+	}
+	return nil
+}
+
+// localTimeouts returns the statement that sets the timeouts of a migration
+// run in a transaction, or "" if the backend can't. Timeouts are always set, so
+// that none linger from an earlier migration in the same transaction, and the
+// statement timeout defaults to the Migrator's StatementTimeout.
+func (p *migratorPart) localTimeouts(statement, lock time.Duration) string {
+
+	if p.dialect.LocalTimeouts == nil {
+		return ""
+	}
+	if statement == 0 {
+		statement = p.statementTimeout
+	}
+	return p.dialect.LocalTimeouts(statement, lock)
+}
+
+func (p *migratorPart) checkRequirement(ctx context.Context, q querier, r schematadriver.Requirement) error {
+
+	version, ok, err := p.versionOf(ctx, q, r.Schema)
+	if err != nil {
+		return fmt.Errorf("while checking requirement %s of schema %s: %w", r, p.schema, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: schema %s requires %s, which has no version applied", ErrRequirementNotMet, p.schema, r)
+	}
+	if version < r.Version {
+		return fmt.Errorf("%w: schema %s requires %s, which is at version %d", ErrRequirementNotMet, p.schema, r, version)
+	}
+	return nil
+}
+
+// versionOf returns a function that reads the applied version of any schema
+// from its migrations table with q. A dirty version doesn't count as applied.
+func (m *Migrator) versionOf(db *sql.DB) func(ctx context.Context, q querier, schema string) (uint, bool, error) {
+
+	return func(ctx context.Context, q querier, schema string) (uint, bool, error) {
+
+		if _, ok := findSchema(schema, m.Schemata); !ok {
+			return 0, false, fmt.Errorf("couldn't find schema %s: %w", schema, ErrNoSchema)
+		}
+		version, dirty, ok, err := m.readVersion(ctx, db, q, m.tableName(schema))
+		if err != nil || !ok {
+			return 0, false, err
+		}
+		if dirty || version < 0 {
+			return 0, false, nil
+		}
+		return uint(version), true, nil
+	}
+}
+
+// driverExec returns a function that runs statements in the session of the
+// part's database driver.
+func (p *migratorPart) driverExec() func(query string) error {
+	return func(query string) error {
+		return p.driver.Run(strings.NewReader(query))
+	}
+}
@@ -0,0 +1,98 @@
+package multimigrator
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUp_Directives(t *testing.T) {
+
+	type testCase struct {
+		name  string
+		files map[string]string
+		err   error
+	}
+	tcs := []testCase{
+		{
+			name: "Requirement that's applied earlier in the run",
+			files: map[string]string{
+				"0002_02_lenders_Start.up.sql": "-- multimigrator:requires=banks@2\nCREATE TABLE lenders (id INTEGER);\n",
+			},
+		},
+		{
+			name: "Requirement that isn't applied yet",
+			files: map[string]string{
+				"0001_02_lenders_Start.up.sql": "-- multimigrator:requires=banks@2\nCREATE TABLE lenders (id INTEGER);\n",
+			},
+			err: ErrRequirementNotMet,
+		},
+		{
+			name: "Timeouts aren't supported by SQLite",
+			files: map[string]string{
+				"0001_02_lenders_Start.up.sql": "-- multimigrator:timeout=1m\nCREATE TABLE lenders (id INTEGER);\n",
+			},
+			err: ErrUnsupportedDirective,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{
+				"0001_01_banks_Start.up.sql":   "CREATE TABLE banks (id INTEGER);\n",
+				"0002_01_banks_AddName.up.sql": "ALTER TABLE banks ADD COLUMN name TEXT;\n",
+			}
+			for name, body := range tc.files {
+				files[name] = body
+			}
+			m := sqliteMigrator(t, testFS([]string{"banks", "lenders"}, files))
+			err := m.Up("lenders", openSQLite(t))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDialectTimeouts(t *testing.T) {
+
+	set, reset, err := Postgres.Dialect().Timeouts(5*time.Minute, 2*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "SET statement_timeout = 300000; SET lock_timeout = 2000", set)
+	assert.Equal(t, "RESET statement_timeout; RESET lock_timeout", reset)
+
+	set, _, err = MySQL.Dialect().Timeouts(0, 1500*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "SET SESSION lock_wait_timeout = 2, innodb_lock_wait_timeout = 2", set)
+	_, _, err = MySQL.Dialect().Timeouts(time.Minute, 0)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, SQLite.Dialect().Timeouts)
+
+	local := Postgres.Dialect().LocalTimeouts
+	assert.Equal(t, "SET LOCAL statement_timeout = 300000; SET LOCAL lock_timeout TO DEFAULT", local(5*time.Minute, 0))
+	assert.Nil(t, MySQL.Dialect().LocalTimeouts)
+}
+
+func TestValidate_Directives(t *testing.T) {
+
+	fsys := testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql":   "-- multimigrator:timeout=soon\n",
+		"0001_01_first_Start.down.sql": "",
+		"0002_01_first_Amend.up.sql":   "-- multimigrator:requires=second@1\n",
+		"0002_01_first_Amend.down.sql": "",
+	})
+	m, err := NewMigratorFS(fsys, nil, false)
+	assert.Nil(t, err)
+
+	issues, err := m.Validate()
+	assert.Nil(t, err)
+	files := make([]string, 0)
+	for _, vi := range issues {
+		assert.Equal(t, IssueInvalidDirective, vi.Kind)
+		files = append(files, vi.File)
+	}
+	assert.Equal(t, []string{"0001_01_first_Start.up.sql", "0002_01_first_Amend.up.sql"}, files)
+}
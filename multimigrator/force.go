@@ -0,0 +1,121 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// DirtySchema describes a schema whose last migration failed part way through.
+type DirtySchema struct {
+	Schema  string `json:"schema"`
+	Version uint   `json:"version"`
+	// UpPath is the up file for Version, which is the file that was running if
+	// an up migration failed.
+	UpPath string `json:"up_path,omitempty"`
+	// DownPath is the down file for the version after Version, which is the
+	// file that was running if a down migration failed.
+	DownPath string `json:"down_path,omitempty"`
+}
+
+// Force sets the version of a schema without running any migrations, and
+// clears its dirty flag. A version of -1 marks the schema as having no
+// migrations applied. The migrations up to version count as applied, and the
+// checksums of any that weren't recorded are. Use it to recover after fixing
+// up a failed migration by hand.
+func (m *Migrator) Force(schema string, version int, db *sql.DB) error {
+	return m.ForceContext(context.Background(), schema, version, db)
+}
+
+// ForceContext is like Force, but uses ctx while connecting to the database.
+func (m *Migrator) ForceContext(ctx context.Context, schema string, version int, db *sql.DB) error {
+
+	index, ok := findSchema(schema, m.Schemata)
+	if !ok {
+		return fmt.Errorf("couldn't find schema %s: %w", schema, ErrNoSchema)
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, []int{index}, true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
+
+	err = migrators[0].instance.Force(version)
+	if err != nil {
+		return fmt.Errorf("while forcing schema %s to version %d: %w", schema, version, err)
+	}
+	if version >= 0 && migrators[0].checksums != nil {
+		err = migrators[0].recordUpTo(ctx, uint(version))
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("Forced schema version", "schema", schema, "version", version)
+
+	return nil
+}
+
+// Dirty lists the schemata whose last migration failed, along with the files
+// that may have been running at the time, so that an operator can decide which
+// version to Force each of them to.
+func (m *Migrator) Dirty(db *sql.DB) ([]DirtySchema, error) {
+	return m.DirtyContext(context.Background(), db)
+}
+
+// DirtyContext is like Dirty, but uses ctx while connecting to the database.
+func (m *Migrator) DirtyContext(ctx context.Context, db *sql.DB) ([]DirtySchema, error) {
+
+	migrators, _, err := m.openParts(ctx, db, schemaRange(0, len(m.Schemata)), false)
+	if err != nil {
+		return nil, err
+	}
+	defer migrators.close()
+
+	return migrators.dirty(m.RootDir)
+}
+
+func (mp migratorParts) dirty(rootDir string) ([]DirtySchema, error) {
+
+	ret := make([]DirtySchema, 0)
+	for _, p := range mp {
+		version, dirty, err := p.instance.Version()
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			return nil, err
+		}
+		if !dirty {
+			continue
+		}
+		ds := DirtySchema{
+			Schema:  p.schema,
+			Version: version,
+		}
+		if mig, err := p.sourceDrv.Migration(version, source.Up); err == nil {
+			ds.UpPath = migrationPath(rootDir, mig)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		next, err := p.sourceDrv.Next(version)
+		if err == nil {
+			if mig, err := p.sourceDrv.Migration(next, source.Down); err == nil {
+				ds.DownPath = migrationPath(rootDir, mig)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		ret = append(ret, ds)
+	}
+
+	return ret, nil
+}
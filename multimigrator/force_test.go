@@ -0,0 +1,53 @@
+package multimigrator
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestDirty(t *testing.T) {
+
+	mp, _ := newMockMigratorParts([][]uint{{1, 2, 3}, {2, 3, 4}, {1, 2}})
+	mp[0].instance.(*mockMigrator).cursor = 2
+	second := mp[1].instance.(*mockMigrator)
+	second.cursor = 1
+	second.dirty = true
+	third := mp[2].instance.(*mockMigrator)
+	third.cursor = 1
+	third.dirty = true
+
+	dirty, err := mp.dirty("/migrations")
+	assert.Nil(t, err)
+	assert.Equal(t, []DirtySchema{
+		{
+			Schema:   "schema1",
+			Version:  3,
+			UpPath:   "/migrations/0003_02_schema1_Mock.up.sql",
+			DownPath: "/migrations/0004_02_schema1_Mock.down.sql",
+		},
+		{
+			Schema:  "schema2",
+			Version: 2,
+			UpPath:  "/migrations/0002_03_schema2_Mock.up.sql",
+		},
+	}, dirty)
+}
+
+func TestDirty_ReadOnly(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":  "CREATE TABLE first_items (id INTEGER);\n",
+		"0002_01_first_Amend.up.sql":  "ALTER TABLE nope ADD COLUMN name TEXT;\n",
+		"0001_02_second_Start.up.sql": "CREATE TABLE second_items (id INTEGER);\n",
+	}))
+	db := openSQLite(t)
+	assert.NotNil(t, m.Up("first", db))
+	tables := sqliteTables(t, db)
+
+	dirty, err := m.Dirty(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []DirtySchema{{Schema: "first", Version: 2, UpPath: "0002_01_first_Amend.up.sql"}}, dirty)
+	assert.Equal(t, tables, sqliteTables(t, db), "listing dirty schemata must not create any tables")
+	assert.NotContains(t, tables, LegacyTableName("second"))
+}
@@ -0,0 +1,188 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// GoMigrationFunc is one direction of a migration written in Go. It runs inside
+// tx, which is committed if it returns nil and rolled back otherwise.
+type GoMigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+type goMigration struct {
+	identifier string
+	up         GoMigrationFunc
+	down       GoMigrationFunc
+}
+
+var (
+	goMigrationsMu sync.Mutex
+	goMigrations   = make(map[string]map[uint]*goMigration)
+)
+
+// RegisterGoMigration registers a migration written in Go as the given version
+// of schema. It's ordered with the schema's SQL files as if it were one, and is
+// recorded in the same migrations table. down may be nil if the migration can't
+// be reverted. It's meant to be called from init functions, and panics if up is
+// nil or the version is already registered.
+func RegisterGoMigration(schema string, version uint, up, down GoMigrationFunc) {
+
+	if up == nil {
+		panic(fmt.Sprintf("multimigrator: up function for version %d of schema %s is nil", version, schema))
+	}
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	versions, ok := goMigrations[schema]
+	if !ok {
+		versions = make(map[uint]*goMigration)
+		goMigrations[schema] = versions
+	}
+	if _, dup := versions[version]; dup {
+		panic(fmt.Sprintf("multimigrator: RegisterGoMigration called twice for version %d of schema %s", version, schema))
+	}
+	versions[version] = &goMigration{identifier: funcName(up), up: up, down: down}
+}
+
+// funcName returns the unqualified name of fn, which identifies the migration.
+func funcName(fn GoMigrationFunc) string {
+
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// registeredGoMigrations returns the Go migrations registered for schema.
+func registeredGoMigrations(schema string) map[uint]*goMigration {
+
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	ret := make(map[uint]*goMigration, len(goMigrations[schema]))
+	for version, gm := range goMigrations[schema] {
+		ret[version] = gm
+	}
+	return ret
+}
+
+// addGoMigrations adds the Go migrations to the schema's source driver.
+func addGoMigrations(sourceDrv source.Driver, migrations map[uint]*goMigration) error {
+
+	if len(migrations) == 0 {
+		return nil
+	}
+	driver, ok := sourceDrv.(*schematadriver.SchemataDriver)
+	if !ok {
+		return errors.New("source driver doesn't support Go migrations")
+	}
+	for version, gm := range migrations {
+		err := driver.Add(&source.Migration{Version: version, Identifier: gm.identifier, Direction: source.Up})
+		if err == nil && gm.down != nil {
+			err = driver.Add(&source.Migration{Version: version, Identifier: gm.identifier, Direction: source.Down})
+		}
+		if errors.Is(err, schematadriver.ErrDuplicateVersion) {
+			return fmt.Errorf("version %d has both a SQL file and a Go migration: %w", version, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goRunner runs a schema's Go migrations, recording them in the migrations
+// table the way golang-migrate records SQL files.
+type goRunner struct {
+	db *sql.DB
+	// table is the schema's migrations table
+	table     string
+	dialect   Dialect
+	sourceDrv interface {
+		Prev(version uint) (uint, error)
+	}
+	migrations map[uint]*goMigration
+}
+
+func (r *goRunner) has(version uint) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.migrations[version]
+	return ok
+}
+
+// run applies or reverts the Go migration for step, first running timeouts in
+// its transaction if it's set.
+func (r *goRunner) run(ctx context.Context, step StepInfo, timeouts string) error {
+
+	gm := r.migrations[step.Version]
+	fn := gm.up
+	target := int(step.Version)
+	if step.Direction == source.Down {
+		if gm.down == nil {
+			return fmt.Errorf("no down migration for version %d of schema %s: %w", step.Version, step.Schema, os.ErrNotExist)
+		}
+		fn = gm.down
+		target = database.NilVersion
+		prev, err := r.sourceDrv.Prev(step.Version)
+		if err == nil {
+			target = int(prev)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return r.runAt(ctx, gm, fn, target, timeouts)
+}
+
+// runAt runs fn in a transaction that also records version in the migrations
+// table, so that both are committed or neither is. On backends that can't roll
+// back schema changes, the table is marked dirty before fn runs, so that a
+// failure has to be repaired with Force. timeouts, if set, runs first in the
+// transaction.
+func (r *goRunner) runAt(ctx context.Context, gm *goMigration, fn GoMigrationFunc, version int, timeouts string) error {
+
+	// Like a SQL migration, a Go migration isn't interrupted once it's started
+	ctx = context.WithoutCancel(ctx)
+	if !r.dialect.TransactionalDDL {
+		err := writeVersion(ctx, r.db, r.dialect, r.table, version, true)
+		if err != nil {
+			return fmt.Errorf("while marking version %d dirty: %w", version, err)
+		}
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("while beginning transaction: %w", err)
+	}
+	if timeouts != "" {
+		_, err = tx.ExecContext(ctx, timeouts)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("while setting timeouts: %w", err)
+		}
+	}
+	err = fn(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("in Go migration %s: %w", gm.identifier, err)
+	}
+	err = writeVersion(ctx, tx, r.dialect, r.table, version, false)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("while setting version %d: %w", version, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("while committing Go migration %s: %w", gm.identifier, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func seedGadgets(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO gadgets (id) VALUES (1), (2)`)
+	return err
+}
+
+func unseedGadgets(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM gadgets`)
+	return err
+}
+
+func failGizmos(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO gizmos (id) VALUES (1)`)
+	if err != nil {
+		return err
+	}
+	return errBoom
+}
+
+func init() {
+	RegisterGoMigration("gadgets", 2, seedGadgets, unseedGadgets)
+	RegisterGoMigration("gizmos", 2, failGizmos, nil)
+}
+
+func TestGoMigration(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"gadgets"}, map[string]string{
+		"0001_01_gadgets_Start.up.sql":     "CREATE TABLE gadgets (id INTEGER);\n",
+		"0001_01_gadgets_Start.down.sql":   "DROP TABLE gadgets;\n",
+		"0003_01_gadgets_AddName.up.sql":   "ALTER TABLE gadgets ADD COLUMN name TEXT;\n",
+		"0003_01_gadgets_AddName.down.sql": "ALTER TABLE gadgets DROP COLUMN name;\n",
+	}))
+	db := openSQLite(t)
+
+	plan, err := m.Plan("gadgets", db)
+	assert.Nil(t, err)
+	assert.Len(t, plan, 3)
+	assert.Equal(t, "multimigrator.seedGadgets", plan[1].Identifier)
+	assert.Empty(t, plan[1].Path)
+
+	err = m.Up("gadgets", db)
+	assert.Nil(t, err)
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM gadgets WHERE name IS NULL`).Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	err = m.DownSteps(1, db)
+	assert.Nil(t, err)
+	err = m.DownSteps(1, db)
+	assert.Nil(t, err)
+	err = db.QueryRow(`SELECT COUNT(*) FROM gadgets`).Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), statuses[0].Version)
+}
+
+func TestGoMigration_Failed(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"gizmos"}, map[string]string{
+		"0001_01_gizmos_Start.up.sql": "CREATE TABLE gizmos (id INTEGER);\n",
+	}))
+	db := openSQLite(t)
+
+	err := m.Up("gizmos", db)
+	assert.ErrorIs(t, err, errBoom)
+	// The transaction was rolled back along with the version it would have
+	// recorded, so the schema isn't left dirty
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM gizmos`).Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.False(t, statuses[0].Dirty)
+	assert.Equal(t, uint(1), statuses[0].Version)
+}
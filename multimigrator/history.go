@@ -0,0 +1,185 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// HistoryTable is the table that records every migration step when a
+// Migrator's RecordHistory is set.
+const HistoryTable = "multimigrator_history"
+
+// HistoryEntry is a single migration step, successful or not.
+type HistoryEntry struct {
+	Schema     string           `json:"schema"`
+	Version    uint             `json:"version"`
+	Identifier string           `json:"identifier"`
+	Direction  source.Direction `json:"direction"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Duration   time.Duration    `json:"duration"`
+	Success    bool             `json:"success"`
+	Error      string           `json:"error,omitempty"`
+	Hostname   string           `json:"hostname"`
+}
+
+// HistoryFilter narrows the entries returned by History.
+type HistoryFilter struct {
+	// Schema limits the entries to a single schema when it isn't empty.
+	Schema string
+	// Limit is the maximum number of entries to return, or zero for all.
+	Limit int
+}
+
+type historyStore interface {
+	record(ctx context.Context, entry HistoryEntry) error
+}
+
+type sqlHistoryStore struct {
+	db       *sql.DB
+	dialect  Dialect
+	hostname string
+}
+
+func newHistoryStore(ctx context.Context, db *sql.DB, dialect Dialect) (*sqlHistoryStore, error) {
+
+	query := `CREATE TABLE IF NOT EXISTS ` + HistoryTable + ` (
+	id ` + dialect.Serial + `,
+	schema_name ` + dialect.Text + ` NOT NULL,
+	version BIGINT NOT NULL,
+	identifier TEXT NOT NULL,
+	direction TEXT NOT NULL,
+	started_at ` + dialect.Timestamp + ` NOT NULL,
+	finished_at ` + dialect.Timestamp + ` NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	success BOOLEAN NOT NULL,
+	error TEXT,
+	hostname TEXT NOT NULL
+)`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, fmt.Errorf("while creating %s: %w", HistoryTable, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &sqlHistoryStore{db: db, dialect: dialect, hostname: hostname}, nil
+}
+
+func (s *sqlHistoryStore) record(ctx context.Context, entry HistoryEntry) error {
+
+	var errText sql.NullString
+	if entry.Error != "" {
+		errText = sql.NullString{String: entry.Error, Valid: true}
+	}
+	// Record the step even if the run was cancelled during it
+	ctx = context.WithoutCancel(ctx)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+HistoryTable+`
+(schema_name, version, identifier, direction, started_at, finished_at, duration_ms, success, error, hostname)
+VALUES `+placeholders(s.dialect, 10),
+		entry.Schema, int64(entry.Version), entry.Identifier, string(entry.Direction), entry.StartedAt, entry.FinishedAt,
+		entry.Duration.Milliseconds(), entry.Success, errText, s.hostname)
+	return err
+}
+
+// History returns the recorded migration steps, most recent first. Steps are
+// only recorded while a Migrator's RecordHistory is set, and History is empty
+// until the first run that records them.
+func (m *Migrator) History(db *sql.DB, filter HistoryFilter) ([]HistoryEntry, error) {
+	return m.HistoryContext(context.Background(), db, filter)
+}
+
+// HistoryContext is like History, but uses ctx for the query.
+func (m *Migrator) HistoryContext(ctx context.Context, db *sql.DB, filter HistoryFilter) ([]HistoryEntry, error) {
+
+	exists, err := m.backend().TableExists(ctx, db, HistoryTable)
+	if err != nil {
+		return nil, fmt.Errorf("while checking for %s: %w", HistoryTable, err)
+	}
+	if !exists {
+		return make([]HistoryEntry, 0), nil
+	}
+	p := m.backend().Dialect().Placeholder
+	var sb strings.Builder
+	sb.WriteString(`SELECT schema_name, version, identifier, direction, started_at, finished_at, duration_ms, success, error, hostname FROM ` + HistoryTable)
+	args := make([]any, 0)
+	if filter.Schema != "" {
+		args = append(args, filter.Schema)
+		sb.WriteString(` WHERE schema_name = ` + p(len(args)))
+	}
+	sb.WriteString(` ORDER BY id DESC`)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		sb.WriteString(` LIMIT ` + p(len(args)))
+	}
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var e HistoryEntry
+		var version, durationMs int64
+		var direction string
+		var errText sql.NullString
+		err = rows.Scan(&e.Schema, &version, &e.Identifier, &direction, &e.StartedAt, &e.FinishedAt, &durationMs, &e.Success, &errText, &e.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		e.Version = uint(version)
+		e.Direction = source.Direction(direction)
+		e.Duration = time.Duration(durationMs) * time.Millisecond
+		e.Error = errText.String
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+// newHistoryEntry describes step, which started at started and failed with
+// stepErr if it isn't nil.
+func newHistoryEntry(step StepInfo, started time.Time, stepErr error) HistoryEntry {
+
+	finished := time.Now()
+	entry := HistoryEntry{
+		Schema:     step.Schema,
+		Version:    step.Version,
+		Identifier: step.Identifier,
+		Direction:  step.Direction,
+		StartedAt:  started,
+		FinishedAt: finished,
+		Duration:   finished.Sub(started),
+		Success:    stepErr == nil,
+	}
+	if stepErr != nil {
+		entry.Error = stepErr.Error()
+	}
+	return entry
+}
+
+// recordHistory appends the entry of a step to the history.
+func (p *migratorPart) recordHistory(ctx context.Context, entry HistoryEntry) error {
+
+	err := p.history.record(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("while recording history of version %d for schema %s: %w", entry.Version, p.schema, err)
+	}
+	return nil
+}
+
+// placeholders returns a parenthesised list of n bind parameters.
+func placeholders(dialect Dialect, n int) string {
+
+	params := make([]string, n)
+	for i := range params {
+		params[i] = dialect.Placeholder(i + 1)
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
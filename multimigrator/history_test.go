@@ -0,0 +1,82 @@
+package multimigrator
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	assert "github.com/stretchr/testify/require"
+)
+
+type mockHistoryStore struct {
+	entries []HistoryEntry
+}
+
+func (s *mockHistoryStore) record(ctx context.Context, entry HistoryEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func TestHistory(t *testing.T) {
+
+	ctx := context.Background()
+	mp, _ := newMockMigratorParts([][]uint{{1, 2}, {2}})
+	store := &mockHistoryStore{}
+	for _, p := range mp {
+		p.history = store
+	}
+
+	err := mp.applyMigrations(ctx, discardLogger(), math.MaxUint)
+	assert.Nil(t, err)
+	err = mp.revertMigrations(ctx, discardLogger(), 1, 0)
+	assert.Nil(t, err)
+	failure := errors.New("syntax error")
+	mp[1].instance.(*mockMigrator).err = failure
+	err = mp.applyMigrations(ctx, discardLogger(), math.MaxUint)
+	assert.ErrorIs(t, err, failure)
+
+	type summary struct {
+		schema     string
+		version    uint
+		identifier string
+		direction  source.Direction
+		success    bool
+		err        string
+	}
+	summaries := make([]summary, len(store.entries))
+	for i, e := range store.entries {
+		assert.False(t, e.FinishedAt.Before(e.StartedAt))
+		summaries[i] = summary{e.Schema, e.Version, e.Identifier, e.Direction, e.Success, e.Error}
+	}
+	assert.Equal(t, []summary{
+		{"schema0", 1, "01_schema0_Mock", source.Up, true, ""},
+		{"schema0", 2, "01_schema0_Mock", source.Up, true, ""},
+		{"schema1", 2, "02_schema1_Mock", source.Up, true, ""},
+		{"schema1", 2, "02_schema1_Mock", source.Down, true, ""},
+		{"schema1", 2, "02_schema1_Mock", source.Up, false, "syntax error"},
+	}, summaries)
+}
+
+func TestHistory_NoTable(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql": "CREATE TABLE first_items (id INTEGER);\n",
+	}))
+	m.RecordHistory = true
+	db := openSQLite(t)
+
+	entries, err := m.History(db, HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+	// Only runs that change the database create the table
+	_, err = m.Status(db)
+	assert.Nil(t, err)
+	assert.Empty(t, sqliteTables(t, db))
+
+	assert.Nil(t, m.Up("first", db))
+	entries, err = m.History(db, HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
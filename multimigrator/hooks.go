@@ -0,0 +1,85 @@
+package multimigrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+var ErrStepVetoed = errors.New("migration step vetoed by hook")
+
+// Hooks are called around each run that changes the database, and around each
+// migration step within it. Embed NoopHooks to implement only some of them.
+//
+// A Goto that has to revert migrations before applying others calls the step
+// hooks for both directions within a single run.
+type Hooks interface {
+	// BeforeRun is called once the lock is held, before any step. An error
+	// stops the run.
+	BeforeRun(ctx context.Context, run RunInfo) error
+	// BeforeStep is called before each step. An error vetoes the step and stops
+	// the run with ErrStepVetoed.
+	BeforeStep(ctx context.Context, step StepInfo) error
+	// AfterStep is called after each successful step.
+	AfterStep(ctx context.Context, step StepInfo, duration time.Duration)
+	// OnError is called when a step fails.
+	OnError(ctx context.Context, step StepInfo, err error)
+	// AfterRun is called at the end of every run that BeforeRun allowed, with
+	// the error the run returns, if any.
+	AfterRun(ctx context.Context, run RunInfo, err error)
+}
+
+// RunInfo describes a run.
+type RunInfo struct {
+	// Schemata are the schemata whose migrations the run may change, in order.
+	Schemata []string
+}
+
+// StepInfo describes a single migration step. For a repeatable migration,
+// Version is the schema's applied version and Identifier is the file name.
+type StepInfo struct {
+	Schema     string
+	Version    uint
+	Identifier string
+	Direction  source.Direction
+}
+
+// NoopHooks implements Hooks by doing nothing.
+type NoopHooks struct{}
+
+func (NoopHooks) BeforeRun(context.Context, RunInfo) error { return nil }
+
+func (NoopHooks) BeforeStep(context.Context, StepInfo) error { return nil }
+
+func (NoopHooks) AfterStep(context.Context, StepInfo, time.Duration) {}
+
+func (NoopHooks) OnError(context.Context, StepInfo, error) {}
+
+func (NoopHooks) AfterRun(context.Context, RunInfo, error) {}
+
+func (m *Migrator) hooks() Hooks {
+	if m.Hooks == nil {
+		return NoopHooks{}
+	}
+	return m.Hooks
+}
+
+// run calls fn between the BeforeRun and AfterRun hooks.
+func (m *Migrator) run(ctx context.Context, mp migratorParts, fn func() error) error {
+
+	hooks := m.hooks()
+	run := RunInfo{Schemata: make([]string, 0, len(mp))}
+	for _, p := range mp {
+		run.Schemata = append(run.Schemata, p.schema)
+	}
+	err := hooks.BeforeRun(ctx, run)
+	if err != nil {
+		return fmt.Errorf("while running before run hook: %w", err)
+	}
+	err = fn()
+	hooks.AfterRun(ctx, run, err)
+	return err
+}
@@ -0,0 +1,142 @@
+package multimigrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type recordingHooks struct {
+	NoopHooks
+	events []string
+	// vetoVersion makes BeforeStep veto the step to this version
+	vetoVersion uint
+	runErr      error
+}
+
+func (h *recordingHooks) BeforeRun(_ context.Context, run RunInfo) error {
+	h.events = append(h.events, fmt.Sprintf("before run %v", run.Schemata))
+	return h.runErr
+}
+
+func (h *recordingHooks) BeforeStep(_ context.Context, step StepInfo) error {
+	h.events = append(h.events, fmt.Sprintf("before %s %d %s %s", step.Schema, step.Version, step.Identifier, step.Direction))
+	if step.Version == h.vetoVersion {
+		return errors.New("not today")
+	}
+	return nil
+}
+
+func (h *recordingHooks) AfterStep(_ context.Context, step StepInfo, _ time.Duration) {
+	h.events = append(h.events, fmt.Sprintf("after %s %d", step.Schema, step.Version))
+}
+
+func (h *recordingHooks) OnError(_ context.Context, step StepInfo, err error) {
+	h.events = append(h.events, fmt.Sprintf("error %s %d: %v", step.Schema, step.Version, err))
+}
+
+func (h *recordingHooks) AfterRun(_ context.Context, _ RunInfo, err error) {
+	h.events = append(h.events, fmt.Sprintf("after run: %v", err))
+}
+
+func withHooks(mp migratorParts, hooks Hooks) migratorParts {
+	for _, p := range mp {
+		p.hooks = hooks
+	}
+	return mp
+}
+
+func TestHooks(t *testing.T) {
+
+	type testCase struct {
+		name        string
+		versions    [][]uint
+		vetoVersion uint
+		failSchema  int
+		runErr      error
+		expected    []string
+		err         error
+	}
+	tcs := []testCase{
+		{
+			name:       "Steps are surrounded by hooks",
+			versions:   [][]uint{{1, 2}, {1}},
+			failSchema: -1,
+			expected: []string{
+				"before run [schema0 schema1]",
+				"before schema0 1 01_schema0_Mock up",
+				"after schema0 1",
+				"before schema1 1 02_schema1_Mock up",
+				"after schema1 1",
+				"before schema0 2 01_schema0_Mock up",
+				"after schema0 2",
+				"after run: <nil>",
+			},
+		},
+		{
+			name:        "BeforeStep vetoes a step",
+			versions:    [][]uint{{1, 2}, {1}},
+			vetoVersion: 2,
+			failSchema:  -1,
+			expected: []string{
+				"before run [schema0 schema1]",
+				"before schema0 1 01_schema0_Mock up",
+				"after schema0 1",
+				"before schema1 1 02_schema1_Mock up",
+				"after schema1 1",
+				"before schema0 2 01_schema0_Mock up",
+				"after run: migration step vetoed by hook: version 2 of schema schema0: not today",
+			},
+			err: ErrStepVetoed,
+		},
+		{
+			name:       "OnError is called for a failed step",
+			versions:   [][]uint{{1}, {1}},
+			failSchema: 1,
+			expected: []string{
+				"before run [schema0 schema1]",
+				"before schema0 1 01_schema0_Mock up",
+				"after schema0 1",
+				"before schema1 1 02_schema1_Mock up",
+				"error schema1 1: boom",
+				"after run: boom",
+			},
+			err: errBoom,
+		},
+		{
+			name:       "BeforeRun stops the run",
+			versions:   [][]uint{{1}},
+			failSchema: -1,
+			runErr:     errBoom,
+			expected:   []string{"before run [schema0]"},
+			err:        errBoom,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			hooks := &recordingHooks{vetoVersion: tc.vetoVersion, runErr: tc.runErr}
+			mp, _ := newMockMigratorParts(tc.versions)
+			mp = withHooks(mp, hooks)
+			if tc.failSchema >= 0 {
+				mp[tc.failSchema].instance.(*mockMigrator).err = errBoom
+			}
+			m := &Migrator{Hooks: hooks}
+			err := m.run(context.Background(), mp, func() error {
+				return mp.applyMigrations(context.Background(), discardLogger(), math.MaxUint)
+			})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tc.expected, hooks.events)
+		})
+	}
+}
+
+var errBoom = errors.New("boom")
@@ -0,0 +1,74 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultLockTimeout is how long a Migrator waits for the global lock when its
+// LockTimeout is zero.
+const DefaultLockTimeout = 15 * time.Second
+
+// lockPollInterval is how often an unavailable lock is retried.
+const lockPollInterval = 250 * time.Millisecond
+
+// lockName identifies the global lock within a database. It doesn't depend on
+// the schema ordering, so that runs of releases with different orderings still
+// exclude each other.
+const lockName = "multimigrator"
+
+var ErrLocked = errors.New("another migration run holds the lock")
+
+// lock takes a lock that is held for a whole interleaved run, so that
+// concurrent runs can't interleave between schemata. The per-schema locks taken
+// by golang-migrate only cover a single step. The lock is keyed on the database
+// and lockName, and is held on its own connection until the returned function
+// is called.
+func (m *Migrator) lock(ctx context.Context, db *sql.DB) (func(), error) {
+
+	backend := m.backend()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to database to lock: %w", err)
+	}
+	key, err := backend.LockKey(ctx, conn, lockName)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("while getting lock key: %w", err)
+	}
+
+	timeout := m.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := backend.TryLock(ctx, conn, key)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("while trying to lock database: %w", err)
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			conn.Close()
+			return nil, fmt.Errorf("couldn't lock database within %s: %w", timeout, ErrLocked)
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	return func() {
+		// Use a fresh context, since the run's context may have been cancelled
+		_ = backend.Unlock(context.Background(), conn, key)
+		conn.Close()
+	}, nil
+}
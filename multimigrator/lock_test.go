@@ -0,0 +1,42 @@
+package multimigrator
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_first_Start.up.sql":  {Data: []byte("CREATE TABLE first_items (id INTEGER);\n")},
+		"0001_02_second_Start.up.sql": {Data: []byte("CREATE TABLE second_items (id INTEGER);\n")},
+	}
+	db := openSQLite(t)
+	ctx := context.Background()
+	holder, err := New(WithFS(fsys), WithSchemata([]string{"first"}), WithBackend(SQLite))
+	assert.Nil(t, err)
+	// A release with another schema in its ordering must still be excluded
+	waiter, err := New(WithFS(fsys), WithSchemata([]string{"first", "second"}), WithBackend(SQLite))
+	assert.Nil(t, err)
+	waiter.LockTimeout = 2 * lockPollInterval
+
+	unlock, err := holder.lock(ctx, db)
+	assert.Nil(t, err)
+	started := time.Now()
+	_, err = waiter.lock(ctx, db)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.GreaterOrEqual(t, time.Since(started), waiter.LockTimeout)
+	assert.ErrorIs(t, waiter.Up("second", db), ErrLocked)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = waiter.lock(cancelled, db)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	unlock()
+	assert.Nil(t, waiter.Up("second", db))
+}
@@ -0,0 +1,115 @@
+package multimigrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+func NewMigrateLogger() migrate.Logger {
+	return MigrateLogger{true}
+}
+
+// MigrateLogger is a migrate.Logger that writes to the standard logger.
+type MigrateLogger struct {
+	verbose bool
+}
+
+func (ml MigrateLogger) Printf(format string, v ...any) {
+	log.Printf(format, v...)
+}
+
+func (ml MigrateLogger) Verbose() bool {
+	return ml.verbose
+}
+
+// NilLogger is a migrate.Logger that discards everything.
+type NilLogger struct{}
+
+func (ml NilLogger) Printf(format string, v ...any) {}
+
+func (ml NilLogger) Verbose() bool { return false }
+
+// discardLogger returns a logger that discards everything.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// slogMigrateLogger adapts a *slog.Logger to golang-migrate, whose own progress
+// messages are logged at debug level.
+type slogMigrateLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogMigrateLogger) Printf(format string, v ...any) {
+	l.logger.Debug(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (l slogMigrateLogger) Verbose() bool {
+	return l.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// printfHandler is a slog.Handler that writes records to a migrate.Logger as
+// a message followed by key=value attributes, so that loggers passed to
+// WithLogger keep working. Debug records are only written if the logger is
+// verbose.
+type printfHandler struct {
+	logger migrate.Logger
+	attrs  []slog.Attr
+	group  string
+}
+
+func (h *printfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level > slog.LevelDebug || h.logger.Verbose()
+}
+
+func (h *printfHandler) Handle(_ context.Context, r slog.Record) error {
+
+	var sb strings.Builder
+	sb.WriteString(r.Message)
+	for _, a := range h.attrs {
+		fmt.Fprintf(&sb, " %s=%v", a.Key, a.Value)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&sb, " %s=%v", h.group+a.Key, a.Value)
+		return true
+	})
+	h.logger.Printf("%s", sb.String())
+	return nil
+}
+
+func (h *printfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	prefixed := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	prefixed = append(prefixed, h.attrs...)
+	for _, a := range attrs {
+		prefixed = append(prefixed, slog.Attr{Key: h.group + a.Key, Value: a.Value})
+	}
+	return &printfHandler{logger: h.logger, attrs: prefixed, group: h.group}
+}
+
+func (h *printfHandler) WithGroup(name string) slog.Handler {
+	return &printfHandler{logger: h.logger, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// logStep logs a migration step as a structured event.
+func logStep(logger *slog.Logger, entry HistoryEntry) {
+
+	attrs := []any{
+		"schema", entry.Schema,
+		"version", entry.Version,
+		"identifier", entry.Identifier,
+		"direction", string(entry.Direction),
+		"duration", entry.Duration,
+	}
+	if !entry.Success {
+		logger.Error("Migration step failed", append(attrs, "error", entry.Error)...)
+		return
+	}
+	logger.Info("Migration step finished", attrs...)
+}
@@ -0,0 +1,66 @@
+package multimigrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type bufferLogger struct {
+	lines   []string
+	verbose bool
+}
+
+func (b *bufferLogger) Printf(format string, v ...any) {
+	b.lines = append(b.lines, fmt.Sprintf(format, v...))
+}
+
+func (b *bufferLogger) Verbose() bool {
+	return b.verbose
+}
+
+func TestApplyMigrations_StructuredLogging(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	mp, _ := newMockMigratorParts([][]uint{{1}, {1}})
+	err := mp.applyMigrations(context.Background(), logger, math.MaxUint)
+	assert.Nil(t, err)
+
+	events := make([]map[string]any, 0)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event map[string]any
+		assert.Nil(t, dec.Decode(&event))
+		events = append(events, event)
+	}
+	assert.Len(t, events, 3)
+	assert.Equal(t, "Migration step finished", events[0]["msg"])
+	assert.Equal(t, "schema0", events[0]["schema"])
+	assert.Equal(t, float64(1), events[0]["version"])
+	assert.Equal(t, "01_schema0_Mock", events[0]["identifier"])
+	assert.Equal(t, "up", events[0]["direction"])
+	assert.Contains(t, events[0], "duration")
+	assert.Equal(t, "schema1", events[1]["schema"])
+	assert.Equal(t, "Applied migrations", events[2]["msg"])
+	assert.Equal(t, float64(2), events[2]["applied"])
+}
+
+func TestPrintfHandler(t *testing.T) {
+
+	bl := &bufferLogger{}
+	logger := slog.New(&printfHandler{logger: bl})
+	logger.With("schema", "billing").Info("Applied migrations", "applied", 2)
+	logger.Debug("Hidden unless verbose")
+	assert.Equal(t, []string{"Applied migrations schema=billing applied=2"}, bl.lines)
+
+	bl.verbose = true
+	slogMigrateLogger{logger}.Printf("Start buffering %v\n", "1/u init")
+	assert.Equal(t, "Start buffering 1/u init", bl.lines[1])
+}
@@ -1,210 +1,839 @@
 package multimigrator
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 	"os"
-	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/alexrjones/multimigrator/internal"
 	"github.com/alexrjones/multimigrator/internal/schematadriver"
 
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/source"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
-var ErrNoSchema = errors.New("schema not found")
+var (
+	ErrNoSchema     = errors.New("schema not found")
+	ErrInvalidSteps = errors.New("number of steps must be positive")
+	// ErrDependencyCycle is returned when schema dependencies can't be ordered
+	ErrDependencyCycle = internal.ErrDependencyCycle
+)
 
 type Migrator struct {
-	RootDir     string
-	Schemata    []string
-	driverPaths []string
-	enableLog   bool
+	RootDir  string
+	Schemata []string
+	// LockTimeout is how long to wait for another run's global lock to be
+	// released before failing with ErrLocked. Zero means DefaultLockTimeout.
+	LockTimeout time.Duration
+	// AllowDrift makes Up log applied migrations whose files have changed since
+	// they were applied, rather than failing with ErrChecksumMismatch.
+	AllowDrift bool
+	// AllowOutOfOrder makes Up apply migrations older than their schema's
+	// applied version that were never applied, such as files merged late from
+	// a branch, rather than failing with ErrOutOfOrder. Migrations skipped by
+	// Force count as never applied. Migrations older than any recorded
+	// checksum can't be told apart from applied ones, and are only logged.
+	AllowOutOfOrder bool
+	// Transactions groups the migrations Up runs into transactions, so that a
+	// failure rolls them back rather than leaving a schema dirty. Files with a
+	// "-- multimigrator:no-transaction" comment at the top run by themselves.
+	Transactions TransactionMode
+	// TemplateVars are the variables .sql.tmpl files are rendered with.
+	TemplateVars map[string]string
+	// RecordHistory makes every migration step append an entry to HistoryTable.
+	RecordHistory bool
+	// Backend is the database engine being migrated. Nil means Postgres.
+	Backend Backend
+	// TableName returns the name of the table that records a schema's version.
+	// Nil means LegacyTableName. See TableNameTemplate.
+	TableName func(schema string) string
+	// StatementTimeout limits how long each migration may run, on backends
+	// that support it. Zero means no limit.
+	StatementTimeout time.Duration
+	// Hooks are called around each run and step that changes the database.
+	Hooks Hooks
+	fsys  fs.FS
+	paths map[string][]string
+	// repeatables are the repeatable migration files of each schema
+	repeatables map[string][]string
+	dependsOn   map[string][]string
+	logger      *slog.Logger
 }
 
 type migratorPart struct {
+	schema       string
 	sourceDrv    migrationSource
 	instance     migrationTarget
 	firstVersion uint
+	close        func()
+	checksums    checksumStore
+	history      historyStore
+	hooks        Hooks
+	// table is the migrations table that records the schema's version
+	table   string
+	db      *sql.DB
+	dialect Dialect
+	// statementTimeout limits migrations run in a transaction, which the
+	// driver's statement timeout doesn't apply to
+	statementTimeout time.Duration
+	// versionOf reads the applied version of any schema, for requirements
+	versionOf func(ctx context.Context, q querier, schema string) (uint, bool, error)
+	// driver is the database driver of the migrate instance, which is only
+	// opened for runs that change the database
+	driver database.Driver
+	// goRunner runs the schema's Go migrations, if it has any
+	goRunner *goRunner
+	// repeatables are the schema's repeatable migrations, in the order they run
+	repeatables     []repeatable
+	repeatableStore repeatableStore
+	// stop asks the migrate instance to stop once its current migration finishes
+	stop func()
 }
 
 type migrationSource interface {
 	Next(version uint) (nextVersion uint, err error)
+	ReadUp(version uint) (r io.ReadCloser, identifier string, err error)
+	// ReadUpRaw is like ReadUp, but doesn't render templates
+	ReadUpRaw(version uint) (r io.ReadCloser, identifier string, err error)
+	Migration(version uint, direction source.Direction) (*source.Migration, error)
+	Directives(version uint, direction source.Direction) (schematadriver.Directives, error)
 }
 
 type migrationTarget interface {
 	Version() (version uint, dirty bool, err error)
 	Steps(n int) error
+	Force(version int) error
 }
 
 type migratorParts []*migratorPart
 
+// NewMigrator is like New with WithRootDir and WithSchemata. If enableLog is
+// set, migrations are logged with the standard logger.
 func NewMigrator(rootDir string, schemata []string, enableLog bool) (*Migrator, error) {
+	return New(WithRootDir(rootDir), WithSchemata(schemata), withLog(enableLog))
+}
+
+// NewMigratorFS is like NewMigrator, but reads the migration files from fsys
+// rather than a directory on disk. See WithFS.
+func NewMigratorFS(fsys fs.FS, schemata []string, enableLog bool) (*Migrator, error) {
+	return New(WithFS(fsys), WithSchemata(schemata), withLog(enableLog))
+}
+
+// Up applies all migrations for the schemata up to and including upToSchema,
+// interleaving them by version so that a parent schema's version N is applied
+// before a dependent schema's version N. If the migrator has dependencies, only
+// upToSchema and the schemata it transitively depends on are migrated.
+// Migrations older than their schema's applied version that were never applied
+// make Up fail with ErrOutOfOrder, unless AllowOutOfOrder is set. Changed
+// repeatable migrations run once their schema level is migrated; see
+// RepeatableTable.
+func (m *Migrator) Up(upToSchema string, db *sql.DB) error {
+	return m.UpContext(context.Background(), upToSchema, db)
+}
 
-	var paths map[string][]string
-	var err error
-	rootDir, err = filepath.Abs(rootDir)
+// UpContext is like Up, but stops between migrations once ctx is cancelled.
+// A migration that is running when ctx is cancelled is allowed to finish.
+func (m *Migrator) UpContext(ctx context.Context, upToSchema string, db *sql.DB) error {
+
+	level, err := m.level(upToSchema)
+	if err != nil {
+		return err
+	}
+	err = m.checkTransactions()
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, level, true)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	paths, err = schematadriver.ExpandPaths(rootDir, schemata)
+	defer migrators.close()
+	err = migrators.checkDrift(ctx, logger, m.AllowDrift)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	late, err := m.checkOutOfOrder(ctx, logger, migrators)
+	if err != nil {
+		return err
+	}
+
+	return m.run(ctx, migrators, func() error {
+		if m.Transactions != NoTransactions {
+			return migrators.applyInTransactions(ctx, logger, db, m.backend().Dialect(), m.Transactions, late)
+		}
+		err := migrators.applyOutOfOrder(ctx, logger, late)
+		if err != nil {
+			return err
+		}
+		return migrators.applyMigrations(ctx, logger, math.MaxUint)
+	})
+}
 
-	driverPaths := make([]string, len(schemata))
-	for i := 0; i < len(schemata); i++ {
-		driverPaths[i] = schematadriver.BuildURL(rootDir, paths[schemata[i]])
+// Down reverts every migration belonging to the schemata ordered after
+// downToSchema, leaving the database at that schema level. Migrations are
+// reverted in the reverse of the order Up applies them, so a dependent
+// schema's version N is reverted before its parent's version N. An empty
+// downToSchema reverts all schemata.
+func (m *Migrator) Down(downToSchema string, db *sql.DB) error {
+	return m.DownContext(context.Background(), downToSchema, db)
+}
+
+// DownContext is like Down, but stops between migrations once ctx is cancelled.
+func (m *Migrator) DownContext(ctx context.Context, downToSchema string, db *sql.DB) error {
+
+	index := -1
+	if downToSchema != "" {
+		var ok bool
+		index, ok = findSchema(downToSchema, m.Schemata)
+		if !ok {
+			return fmt.Errorf("couldn't find schema %s: %w", downToSchema, ErrNoSchema)
+		}
+	}
+	if index == len(m.Schemata)-1 {
+		return nil
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
 	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, schemaRange(index+1, len(m.Schemata)), true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
 
-	return &Migrator{
-		RootDir:     rootDir,
-		Schemata:    schemata,
-		driverPaths: driverPaths,
-		enableLog:   enableLog,
-	}, nil
+	return m.run(ctx, migrators, func() error {
+		return migrators.revertMigrations(ctx, logger, -1, 0)
+	})
 }
 
-func (m *Migrator) Up(upToSchema string, db *sql.DB) error {
+// DownSteps reverts the n most recently applied migrations across all schemata,
+// in the reverse of the order Up applies them.
+func (m *Migrator) DownSteps(n int, db *sql.DB) error {
+	return m.DownStepsContext(context.Background(), n, db)
+}
 
-	var logger migrate.Logger = NilLogger{}
-	index, ok := findSchema(upToSchema, m.Schemata)
-	if !ok {
-		return fmt.Errorf("couldn't find schema %s: %w", upToSchema, ErrNoSchema)
+// DownStepsContext is like DownSteps, but stops between migrations once ctx is
+// cancelled.
+func (m *Migrator) DownStepsContext(ctx context.Context, n int, db *sql.DB) error {
+
+	if n < 1 {
+		return fmt.Errorf("invalid number of steps %d: %w", n, ErrInvalidSteps)
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, schemaRange(0, len(m.Schemata)), true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
+
+	return m.run(ctx, migrators, func() error {
+		return migrators.revertMigrations(ctx, logger, n, 0)
+	})
+}
+
+// Goto applies or reverts migrations until every schema up to and including
+// toSchema sits at its highest version <= version. Schemata ordered after
+// toSchema may depend on the ones before it, so any of their versions above the
+// target are reverted too, but nothing is applied to them. If the migrator has
+// dependencies, migrations are only applied to toSchema and the schemata it
+// transitively depends on.
+func (m *Migrator) Goto(toSchema string, version uint, db *sql.DB) error {
+	return m.GotoContext(context.Background(), toSchema, version, db)
+}
+
+// GotoContext is like Goto, but stops between migrations once ctx is cancelled.
+func (m *Migrator) GotoContext(ctx context.Context, toSchema string, version uint, db *sql.DB) error {
+
+	level, err := m.level(toSchema)
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrators, logger, err := m.openParts(ctx, db, schemaRange(0, len(m.Schemata)), true)
+	if err != nil {
+		return err
+	}
+	defer migrators.close()
+	err = migrators.checkDrift(ctx, logger, m.AllowDrift)
+	if err != nil {
+		return err
+	}
+
+	return m.run(ctx, migrators, func() error {
+		return migrators.migrateTo(ctx, logger, level, version)
+	})
+}
+
+// migrateTo reverts every part to its highest version <= version, then applies
+// migrations to the parts at the given indices until they reach that version,
+// running their changed repeatable migrations.
+func (mp migratorParts) migrateTo(ctx context.Context, logger *slog.Logger, apply []int, version uint) error {
+
+	if version < math.MaxUint {
+		err := mp.revertMigrations(ctx, logger, -1, version+1)
+		if err != nil {
+			return err
+		}
+	}
+	return mp.subset(apply).applyMigrations(ctx, logger, version)
+}
+
+// openParts opens a source driver and migrate instance for the schema at each of
+// the given indices of m.Schemata. Each part holds its own connection from db,
+// and the caller must close the returned parts. Callers that change the
+// database set write, and must hold the global lock, so that a legacy
+// migrations table can be adopted. Otherwise the parts only read each schema's
+// version, from the legacy table if it's waiting to be adopted, and nothing is
+// created in the database.
+func (m *Migrator) openParts(ctx context.Context, db *sql.DB, indices []int, write bool) (migratorParts, *slog.Logger, error) {
+
+	logger := m.logger
+	if logger == nil {
+		logger = discardLogger()
+	}
+	dialect := m.backend().Dialect()
+	checksums, err := m.openChecksumStore(ctx, db, write)
+	if err != nil {
+		return nil, nil, err
+	}
+	repeatables, err := m.openRepeatableStore(ctx, db, write)
+	if err != nil {
+		return nil, nil, err
+	}
+	var history historyStore
+	if m.RecordHistory && write {
+		history, err = newHistoryStore(ctx, db, dialect)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	migrators := make(migratorParts, 0, index+1)
+	migrators := make(migratorParts, 0, len(indices))
 
-	for i := 0; i < index+1; i++ {
+	for _, i := range indices {
 
 		schema := m.Schemata[i]
-		sourceDrv, err := source.Open(m.driverPaths[i])
+		sourceDrv, err := schematadriver.WithFS(m.fsys, m.paths[schema])
 		if err != nil {
-			return fmt.Errorf("while opening driver for schema %s: %w", schema, err)
+			migrators.close()
+			return nil, nil, fmt.Errorf("while opening driver for schema %s: %w", schema, err)
+		}
+		goMigrations := registeredGoMigrations(schema)
+		err = addGoMigrations(sourceDrv, goMigrations)
+		if err != nil {
+			sourceDrv.Close()
+			migrators.close()
+			return nil, nil, fmt.Errorf("while adding Go migrations for schema %s: %w", schema, err)
+		}
+		if sd, ok := sourceDrv.(*schematadriver.SchemataDriver); ok {
+			sd.SetTemplateVars(m.TemplateVars)
+			err = sd.CheckTemplates()
+			if err != nil {
+				sourceDrv.Close()
+				migrators.close()
+				return nil, nil, fmt.Errorf("for schema %s: %w", schema, err)
+			}
+		}
+		partRepeatables, err := m.readRepeatables(schema)
+		if err != nil {
+			sourceDrv.Close()
+			migrators.close()
+			return nil, nil, fmt.Errorf("while reading repeatable migrations for schema %s: %w", schema, err)
 		}
-		defer sourceDrv.Close()
 		// Make sure there's at least one migration version available
 		first, err := sourceDrv.First()
 		if err != nil {
-			return fmt.Errorf("while getting first version for schema %s: %w", schema, err)
+			sourceDrv.Close()
+			migrators.close()
+			return nil, nil, fmt.Errorf("while getting first version for schema %s: %w", schema, err)
+		}
+		partSource, ok := sourceDrv.(migrationSource)
+		if !ok {
+			sourceDrv.Close()
+			migrators.close()
+			return nil, nil, fmt.Errorf("source driver for schema %s doesn't expose its migrations", schema)
 		}
-		driver, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: schema + "_" + postgres.DefaultMigrationsTable})
+		table := m.tableName(schema)
+		adopt, err := m.legacyPending(ctx, db, logger, schema, table)
 		if err != nil {
-			return fmt.Errorf("while opening database for schema %s: %w", schema, err)
+			sourceDrv.Close()
+			migrators.close()
+			return nil, nil, fmt.Errorf("while checking legacy migrations table for schema %s: %w", schema, err)
+		}
+		if adopt && !write {
+			logger.Info("Reading legacy migrations table, which the next run that changes the database will adopt", "schema", schema, "legacy_table", LegacyTableName(schema), "table", table)
+			table = LegacyTableName(schema)
+		}
+		part := &migratorPart{
+			schema:           schema,
+			sourceDrv:        partSource,
+			instance:         &tableVersion{ctx: ctx, m: m, db: db, table: table},
+			firstVersion:     first,
+			checksums:        checksums,
+			history:          history,
+			hooks:            m.hooks(),
+			table:            table,
+			db:               db,
+			dialect:          dialect,
+			statementTimeout: m.StatementTimeout,
+			versionOf:        m.versionOf(db),
+			repeatables:      partRepeatables,
+			repeatableStore:  repeatables,
+			close: func() {
+				sourceDrv.Close()
+			},
+		}
+		if write {
+			instance, driver, err := m.openInstance(ctx, db, logger, schema, table, adopt, sourceDrv)
+			if err != nil {
+				sourceDrv.Close()
+				migrators.close()
+				return nil, nil, err
+			}
+			part.instance = instance
+			part.driver = driver
+			part.close = func() {
+				driver.Close()
+				sourceDrv.Close()
+			}
+			part.stop = func() {
+				select {
+				case instance.GracefulStop <- true:
+				default:
+				}
+			}
 		}
-		defer driver.Close()
-		instance, err := migrate.NewWithInstance(schema, sourceDrv, "test", driver)
+		if len(goMigrations) > 0 {
+			part.goRunner = &goRunner{db: db, table: table, dialect: dialect, sourceDrv: sourceDrv.(*schematadriver.SchemataDriver), migrations: goMigrations}
+		}
+		migrators = append(migrators, part)
+	}
+
+	return migrators, logger, nil
+}
+
+// openInstance opens the database driver and migrate instance of a schema for
+// a run that changes the database, first adopting the schema's legacy
+// migrations table if adopt is set.
+func (m *Migrator) openInstance(ctx context.Context, db *sql.DB, logger *slog.Logger, schema, table string, adopt bool, sourceDrv source.Driver) (*migrate.Migrate, database.Driver, error) {
+
+	driver, err := m.backend().Open(ctx, db, DriverConfig{Table: table, StatementTimeout: m.StatementTimeout})
+	if err != nil {
+		return nil, nil, fmt.Errorf("while opening database for schema %s: %w", schema, err)
+	}
+	if adopt {
+		err = m.adoptLegacyTable(ctx, db, driver, logger, schema, table)
 		if err != nil {
-			return fmt.Errorf("while creating migrate instance for schema %s: %w", schema, err)
+			driver.Close()
+			return nil, nil, fmt.Errorf("while adopting legacy migrations table for schema %s: %w", schema, err)
 		}
-		if m.enableLog {
-			logger = NewMigrateLogger()
-			instance.Log = logger
+	}
+	instance, err := migrate.NewWithInstance(schema, sourceDrv, "test", driver)
+	if err != nil {
+		driver.Close()
+		return nil, nil, fmt.Errorf("while creating migrate instance for schema %s: %w", schema, err)
+	}
+	instance.Log = slogMigrateLogger{logger}
+	return instance, driver, nil
+}
+
+func (mp migratorParts) close() {
+
+	for _, p := range mp {
+		if p.close != nil {
+			p.close()
+		}
+	}
+}
+
+// applyMigrations steps the parts up one migration at a time, interleaving them
+// by version, until every part has no more migrations or maxVersion is reached.
+// Each part's repeatable migrations run once its schema level is done.
+func (mp migratorParts) applyMigrations(ctx context.Context, logger *slog.Logger, maxVersion uint) error {
+
+	steps, err := mp.planMigrations(maxVersion)
+	if err != nil {
+		return err
+	}
+	done := mp.levelsDone(steps)
+	appliedCount, repeatableCount := 0, 0
+	for i := range done {
+		if i > 0 {
+			err = mp[steps[i-1].index].steps(ctx, logger, 1)
+			if err != nil {
+				return err
+			}
+			appliedCount++
+		}
+		for _, index := range done[i] {
+			n, err := mp[index].applyRepeatables(ctx, logger)
+			repeatableCount += n
+			if err != nil {
+				return err
+			}
 		}
-		migrators = append(migrators, &migratorPart{
-			sourceDrv:    sourceDrv,
-			instance:     instance,
-			firstVersion: first,
-		})
 	}
 
-	return migrators.applyMigrations(logger)
+	logger.Info("Applied migrations", "applied", appliedCount)
+	if mp.hasRepeatables() {
+		logger.Info("Applied repeatable migrations", "applied", repeatableCount)
+	}
+
+	return nil
+}
+
+// plannedStep identifies a migration that applyMigrations will run: the index
+// of its part and the version the part will be at after stepping up once.
+type plannedStep struct {
+	index   int
+	version uint
+	// outOfOrder is set for a migration older than the part's applied version
+	outOfOrder bool
+}
+
+// planMigrations computes the order in which applyMigrations runs migrations,
+// without running any of them. Versions are interleaved so that a parent
+// schema's version N comes before a dependent schema's version N. Versions
+// can be sparse, such as timestamps, since only the versions each schema has
+// are considered.
+func (mp migratorParts) planMigrations(maxVersion uint) ([]plannedStep, error) {
+
+	steps := make([]plannedStep, 0)
+	for i, p := range mp {
+		// Get the current applied version for this schema
+		applied, _, err := p.instance.Version()
+		hasApplied := err == nil
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			return nil, err
+		}
+		versions, err := p.versions()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if v > maxVersion {
+				break
+			}
+			if !hasApplied || v > applied {
+				steps = append(steps, plannedStep{index: i, version: v})
+			}
+		}
+	}
+	sortSteps(steps)
+
+	return steps, nil
 }
 
-func (mp migratorParts) applyMigrations(logger migrate.Logger) error {
+// sortSteps orders steps by version and, between schemata at the same
+// version, by the order of the schemata.
+func sortSteps(steps []plannedStep) {
+	slices.SortStableFunc(steps, func(a, b plannedStep) int {
+		if c := cmp.Compare(a.version, b.version); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.index, b.index)
+	})
+}
 
-	iter := 0
-	appliedCount := 0
-	var versionToApply uint = 1
-	lastSeenNextVersions := make([]uint, len(mp))
-	done := make([]bool, len(mp))
-	for {
-		if !done[iter] && lastSeenNextVersions[iter] <= versionToApply && mp[iter].firstVersion <= versionToApply {
-			curr := mp[iter]
-			// Get the current applied version for this schema
-			appliedVersion, _, err := curr.instance.Version()
-			if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+// revertMigrations steps the parts down one migration at a time, always
+// choosing the highest applied version and, between schemata at the same
+// version, the one ordered last. This is the reverse of the order
+// applyMigrations uses. Only applied versions >= downTo are reverted, and a
+// negative limit places no bound on the number of steps.
+func (mp migratorParts) revertMigrations(ctx context.Context, logger *slog.Logger, limit int, downTo uint) error {
+
+	revertedCount := 0
+	appliedVersions := make([]uint, len(mp))
+	hasVersion := make([]bool, len(mp))
+	for i, p := range mp {
+		v, _, err := p.instance.Version()
+		if err != nil {
+			if !errors.Is(err, migrate.ErrNilVersion) {
 				return err
 			}
-			if appliedVersion < versionToApply && versionToApply >= curr.firstVersion {
-				var nextVersion uint
-				if versionToApply == curr.firstVersion {
-					nextVersion = curr.firstVersion
-				} else if lastSeenNextVersions[iter] != 0 && lastSeenNextVersions[iter] == versionToApply {
-					nextVersion = versionToApply
-				} else {
-					// Get the next version that this schema has
-					nextVersion, err = curr.sourceDrv.Next(versionToApply - 1)
-					if err != nil {
-						if !errors.Is(err, os.ErrNotExist) {
-							return err
-						}
-						done[iter] = true
-						// We've finished migrating this schema
-						iter = (iter + 1) % len(mp)
-						if iter == 0 {
-							// We've migrated to the current version of our target schema
-							break
-						}
-						// There are still more schemata to migrate
-						continue
-					}
-					if nextVersion > versionToApply {
-						lastSeenNextVersions[iter] = nextVersion
-					}
-				}
-				if nextVersion == versionToApply {
-					err = mp[iter].instance.Steps(1)
-					if err != nil {
-						return err
-					}
-					appliedCount++
-				}
+			continue
+		}
+		appliedVersions[i] = v
+		hasVersion[i] = true
+	}
+	for limit < 0 || revertedCount < limit {
+		iter := -1
+		for i := range mp {
+			if hasVersion[i] && appliedVersions[i] >= downTo && (iter == -1 || appliedVersions[i] >= appliedVersions[iter]) {
+				iter = i
 			}
 		}
-		iter = (iter + 1) % len(mp)
-		if iter == 0 {
-			versionToApply++
+		if iter == -1 {
+			// Every schema has been reverted as far as it needs to go
+			break
+		}
+		err := mp[iter].steps(ctx, logger, -1)
+		if err != nil {
+			return err
 		}
+		revertedCount++
+		v, _, err := mp[iter].instance.Version()
+		if err != nil {
+			if !errors.Is(err, migrate.ErrNilVersion) {
+				return err
+			}
+			hasVersion[iter] = false
+			err = mp[iter].forgetRepeatables(ctx)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		appliedVersions[iter] = v
 	}
 
-	logger.Printf("Ran %d migrations", appliedCount)
+	logger.Info("Reverted migrations", "reverted", revertedCount)
 
 	return nil
 }
 
-func findSchema(name string, schemata []string) (int, bool) {
+// steps runs n migrations on the part, where n is 1 or -1. If ctx is
+// cancelled while they run, the migrate instance is asked to stop gracefully
+// and the context's error is returned once it has.
+func (p *migratorPart) steps(ctx context.Context, logger *slog.Logger, n int) error {
 
-	for i, s := range schemata {
-		if strings.EqualFold(s, name) {
-			return i, true
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.stop != nil {
+		defer context.AfterFunc(ctx, p.stop)()
+	}
+	before, dirty, err := p.instance.Version()
+	hasBefore := err == nil
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	step, err := p.stepInfo(n, before, hasBefore)
+	if err != nil {
+		return err
+	}
+	// Go migrations run in their own transaction
+	exec := p.driverExec()
+	if p.goRunner.has(step.Version) {
+		exec = nil
+	}
+	entry, err := p.runStep(ctx, logger, step, func() error {
+		return p.withDirectives(ctx, p.db, exec, step, func(timeouts string) error {
+			if !p.goRunner.has(step.Version) {
+				return p.instance.Steps(n)
+			}
+			if dirty {
+				return migrate.ErrDirty{Version: int(before)}
+			}
+			return p.goRunner.run(ctx, step, timeouts)
+		})
+	})
+	err = p.finishStep(ctx, entry, err)
+	if err != nil {
+		return err
+	}
+	if p.checksums != nil {
+		err = p.trackChecksum(ctx, n, before)
+		if err != nil {
+			return err
 		}
 	}
 
-	return -1, false
+	return ctx.Err()
+}
+
+// stepTarget returns the version a single step in the given direction
+// migrates, given the version the part is at before the step.
+func (p *migratorPart) stepTarget(n int, before uint, hasBefore bool) (uint, error) {
+
+	if n < 0 {
+		if !hasBefore {
+			return 0, os.ErrNotExist
+		}
+		return before, nil
+	}
+	if !hasBefore {
+		return p.firstVersion, nil
+	}
+	return p.sourceDrv.Next(before)
+}
+
+// stepInfo describes a step of n taken when the part is at version before.
+func (p *migratorPart) stepInfo(n int, before uint, hasBefore bool) (StepInfo, error) {
+
+	step := StepInfo{Schema: p.schema, Direction: source.Up}
+	if n < 0 {
+		step.Direction = source.Down
+	}
+	version, err := p.stepTarget(n, before, hasBefore)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return StepInfo{}, err
+		}
+		return step, nil
+	}
+	step.Version = version
+	if mig, err := p.sourceDrv.Migration(version, step.Direction); err == nil {
+		step.Identifier = mig.Identifier
+	}
+	return step, nil
 }
 
-func NewMigrateLogger() migrate.Logger {
-	return MigrateLogger{true}
+// runStep runs a migration step with fn between the step hooks, and logs it.
+// The returned entry describes the step for the history.
+func (p *migratorPart) runStep(ctx context.Context, logger *slog.Logger, step StepInfo, fn func() error) (HistoryEntry, error) {
+
+	hooks := p.hooks
+	if hooks == nil {
+		hooks = NoopHooks{}
+	}
+	err := hooks.BeforeStep(ctx, step)
+	if err != nil {
+		return HistoryEntry{}, fmt.Errorf("%w: version %d of schema %s: %w", ErrStepVetoed, step.Version, p.schema, err)
+	}
+
+	started := time.Now()
+	err = fn()
+	entry := newHistoryEntry(step, started, err)
+	logStep(logger, entry)
+	if err != nil {
+		hooks.OnError(ctx, step, err)
+	} else {
+		hooks.AfterStep(ctx, step, entry.Duration)
+	}
+	return entry, err
 }
 
-type MigrateLogger struct {
-	verbose bool
+// finishStep records the entry of a step that ran in the history, and returns
+// the step's error or else any error recording it.
+func (p *migratorPart) finishStep(ctx context.Context, entry HistoryEntry, err error) error {
+
+	if p.history != nil && !errors.Is(err, ErrStepVetoed) {
+		historyErr := p.recordHistory(ctx, entry)
+		if err == nil {
+			return historyErr
+		}
+	}
+	return err
 }
 
-func (ml MigrateLogger) Printf(format string, v ...any) {
-	log.Printf(format, v...)
+// trackChecksum records the checksum of the migration that a step up applied.
+// After a step down, it forgets the checksum of the migration that was
+// reverted instead.
+func (p *migratorPart) trackChecksum(ctx context.Context, n int, before uint) error {
+
+	if n < 0 {
+		err := p.checksums.forget(ctx, p.schema, before)
+		if err != nil {
+			return fmt.Errorf("while forgetting checksum of version %d for schema %s: %w", before, p.schema, err)
+		}
+		return nil
+	}
+	after, _, err := p.instance.Version()
+	if err != nil {
+		return err
+	}
+	sum, err := p.checksum(after)
+	if err != nil {
+		return err
+	}
+	err = p.checksums.record(ctx, p.schema, after, sum)
+	if err != nil {
+		return fmt.Errorf("while recording checksum of version %d for schema %s: %w", after, p.schema, err)
+	}
+	return nil
 }
 
-func (ml MigrateLogger) Verbose() bool {
-	return ml.verbose
+// level returns the indices of the schemata that need to be migrated for the
+// given schema level, in the order they should be migrated.
+func (m *Migrator) level(schema string) ([]int, error) {
+
+	index, ok := findSchema(schema, m.Schemata)
+	if !ok {
+		return nil, fmt.Errorf("couldn't find schema %s: %w", schema, ErrNoSchema)
+	}
+	if m.dependsOn == nil {
+		return schemaRange(0, index+1), nil
+	}
+	deps := internal.TransitiveDependencies(m.Schemata, m.dependsOn, m.Schemata[index])
+	ret := make([]int, len(deps))
+	for i, d := range deps {
+		ret[i] = slices.Index(m.Schemata, d)
+	}
+	return ret, nil
+}
+
+// SetDependencies replaces the implicit dependency of each schema on all of the
+// schemata before it with an explicit graph, mapping a schema to the schemata
+// it depends on. Schemata is reordered so every schema comes after its
+// dependencies, keeping the existing order where the dependencies allow.
+// Passing nil restores the implicit dependencies, but not the original order.
+func (m *Migrator) SetDependencies(dependsOn map[string][]string) error {
+
+	if dependsOn == nil {
+		m.dependsOn = nil
+		return nil
+	}
+	sorted, err := internal.SortSchemata(m.Schemata, dependsOn)
+	if err != nil {
+		return err
+	}
+	m.Schemata = sorted
+	m.dependsOn = dependsOn
+	return nil
 }
 
-type NilLogger struct{}
+func (mp migratorParts) subset(indices []int) migratorParts {
+
+	ret := make(migratorParts, len(indices))
+	for i, index := range indices {
+		ret[i] = mp[index]
+	}
+	return ret
+}
 
-func (ml NilLogger) Printf(format string, v ...any) {}
+// schemaRange returns the indices in the half-open range [from, to).
+func schemaRange(from, to int) []int {
 
-func (ml NilLogger) Verbose() bool { return false }
+	ret := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+func findSchema(name string, schemata []string) (int, bool) {
+
+	for i, s := range schemata {
+		if strings.EqualFold(s, name) {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
@@ -1,19 +1,30 @@
 package multimigrator
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
 	assert "github.com/stretchr/testify/require"
 )
 
 type mockMigrator struct {
 	indexInParent int
 	cursor        int
-	versions      []uint
-	downstream    func(iv identifiedVersion)
+	dirty         bool
+	// err is returned by Steps when it's set, as if the migration failed
+	err        error
+	versions   []uint
+	downstream func(iv identifiedVersion)
 }
 
 func (mv *mockMigrator) Next(version uint) (nextVersion uint, err error) {
@@ -28,16 +39,58 @@ func (mv *mockMigrator) Next(version uint) (nextVersion uint, err error) {
 	return
 }
 
+func (mv *mockMigrator) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+
+	m, err := mv.Migration(version, source.Up)
+	if err != nil {
+		return nil, "", err
+	}
+	body := fmt.Sprintf("-- %s", m.Raw)
+	return io.NopCloser(strings.NewReader(body)), m.Identifier, nil
+}
+
+func (mv *mockMigrator) ReadUpRaw(version uint) (r io.ReadCloser, identifier string, err error) {
+	return mv.ReadUp(version)
+}
+
+func (mv *mockMigrator) Migration(version uint, direction source.Direction) (*source.Migration, error) {
+
+	if !slices.Contains(mv.versions, version) {
+		return nil, fmt.Errorf("no version %d (indexInParent: %d), %w", version, mv.indexInParent, os.ErrNotExist)
+	}
+	identifier := fmt.Sprintf("%02d_schema%d_Mock", mv.indexInParent+1, mv.indexInParent)
+	return &source.Migration{
+		Version:    version,
+		Identifier: identifier,
+		Direction:  direction,
+		Raw:        fmt.Sprintf("%04d_%s.%s.sql", version, identifier, direction),
+	}, nil
+}
+
+func (mv *mockMigrator) Directives(uint, source.Direction) (schematadriver.Directives, error) {
+	return schematadriver.Directives{}, nil
+}
+
 func (mv *mockMigrator) Version() (version uint, dirty bool, err error) {
 	if mv.cursor == -1 {
 		return 0, false, migrate.ErrNilVersion
 	}
 
-	return mv.versions[mv.cursor], false, nil
+	return mv.versions[mv.cursor], mv.dirty, nil
+}
+
+func (mv *mockMigrator) Force(version int) error {
+
+	mv.dirty = false
+	mv.cursor = slices.Index(mv.versions, uint(version))
+	return nil
 }
 
 func (mv *mockMigrator) Steps(n int) error {
 
+	if mv.err != nil {
+		return mv.err
+	}
 	for range n {
 		mv.cursor++
 		mv.downstream(identifiedVersion{
@@ -45,6 +98,13 @@ func (mv *mockMigrator) Steps(n int) error {
 			version:       mv.versions[mv.cursor],
 		})
 	}
+	for range -n {
+		mv.downstream(identifiedVersion{
+			indexInParent: mv.indexInParent,
+			version:       mv.versions[mv.cursor],
+		})
+		mv.cursor--
+	}
 	return nil
 }
 
@@ -68,6 +128,7 @@ func newMockMigratorParts(versions [][]uint) (migratorParts, *collector) {
 	for i, v := range versions {
 		mm := &mockMigrator{indexInParent: i, versions: v, cursor: -1, downstream: c.collect}
 		mp = append(mp, &migratorPart{
+			schema:       fmt.Sprintf("schema%d", i),
 			sourceDrv:    mm,
 			instance:     mm,
 			firstVersion: v[0],
@@ -136,13 +197,238 @@ func TestApplyMigrations(t *testing.T) {
 				{2, 900},
 			},
 		},
+		{
+			name:     "Timestamp versions are interleaved",
+			versions: [][]uint{{20261017120000, 20261017130000}, {20261017123000}},
+			expected: []identifiedVersion{
+				{0, 20261017120000},
+				{1, 20261017123000},
+				{0, 20261017130000},
+			},
+		},
+		{
+			name:     "Parent schema with later versions than its dependent is fully applied",
+			versions: [][]uint{{1, 2, 3}, {1}},
+			expected: []identifiedVersion{
+				{0, 1},
+				{1, 1},
+				{0, 2},
+				{0, 3},
+			},
+		},
 	}
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
 			mp, c := newMockMigratorParts(tc.versions)
-			err := mp.applyMigrations()
+			err := mp.applyMigrations(context.Background(), discardLogger(), math.MaxUint)
 			assert.Nil(t, err)
 			assert.Equal(t, tc.expected, c.identifiedVersions)
 		})
 	}
 }
+
+func TestRevertMigrations(t *testing.T) {
+
+	type testCase struct {
+		name string
+		// The input versions (a numeric series)
+		versions [][]uint
+		limit    int
+		expected []identifiedVersion
+	}
+	tcs := []testCase{
+		{
+			name:     "Dependent schema is reverted before its parent at the same version",
+			versions: [][]uint{{1, 2, 3}, {2, 3, 4}},
+			limit:    -1,
+			expected: []identifiedVersion{
+				{1, 4},
+				{1, 3},
+				{0, 3},
+				{1, 2},
+				{0, 2},
+				{0, 1},
+			},
+		},
+		{
+			name:     "Sparse schemata are reverted in reverse global order",
+			versions: [][]uint{{1, 2, 3}, {800}, {800, 900}},
+			limit:    -1,
+			expected: []identifiedVersion{
+				{2, 900},
+				{2, 800},
+				{1, 800},
+				{0, 3},
+				{0, 2},
+				{0, 1},
+			},
+		},
+		{
+			name:     "Limit stops after the given number of steps",
+			versions: [][]uint{{1, 2, 3}, {1, 2, 3}},
+			limit:    3,
+			expected: []identifiedVersion{
+				{1, 3},
+				{0, 3},
+				{1, 2},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			mp, c := newMockMigratorParts(tc.versions)
+			err := mp.applyMigrations(context.Background(), discardLogger(), math.MaxUint)
+			assert.Nil(t, err)
+			c.identifiedVersions = c.identifiedVersions[:0]
+			err = mp.revertMigrations(context.Background(), discardLogger(), tc.limit, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, c.identifiedVersions)
+		})
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+
+	type testCase struct {
+		name string
+		// The input versions (a numeric series)
+		versions [][]uint
+		// The version each part starts at, or 0 for none
+		start    []uint
+		apply    []int
+		version  uint
+		expected []identifiedVersion
+	}
+	tcs := []testCase{
+		{
+			name:     "Applies interleaved migrations up to the target version",
+			versions: [][]uint{{1, 2, 3}, {2, 3, 4}},
+			start:    []uint{0, 0},
+			apply:    []int{0, 1},
+			version:  2,
+			expected: []identifiedVersion{
+				{0, 1},
+				{0, 2},
+				{1, 2},
+			},
+		},
+		{
+			name:     "Reverts dependent schemata first down to the target version",
+			versions: [][]uint{{1, 2, 3}, {2, 3, 4}},
+			start:    []uint{3, 4},
+			apply:    []int{0, 1},
+			version:  2,
+			expected: []identifiedVersion{
+				{1, 4},
+				{1, 3},
+				{0, 3},
+			},
+		},
+		{
+			name:     "Schemata after the target level are reverted but not applied",
+			versions: [][]uint{{1, 2, 3}, {1, 2, 3}, {1, 2, 3}},
+			start:    []uint{1, 0, 3},
+			apply:    []int{0, 1},
+			version:  2,
+			expected: []identifiedVersion{
+				{2, 3},
+				{1, 1},
+				{0, 2},
+				{1, 2},
+			},
+		},
+		{
+			name:     "Only the schemata in the target level are applied",
+			versions: [][]uint{{1, 2, 3}, {1, 2, 3}, {1, 2, 3}},
+			start:    []uint{0, 0, 0},
+			apply:    []int{0, 2},
+			version:  2,
+			expected: []identifiedVersion{
+				{0, 1},
+				{2, 1},
+				{0, 2},
+				{2, 2},
+			},
+		},
+		{
+			name:     "Sparse schemata settle at their highest version below the target",
+			versions: [][]uint{{1, 2, 3}, {800}, {800, 900}},
+			start:    []uint{0, 0, 0},
+			apply:    []int{0, 1, 2},
+			version:  850,
+			expected: []identifiedVersion{
+				{0, 1},
+				{0, 2},
+				{0, 3},
+				{1, 800},
+				{2, 800},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			mp, c := newMockMigratorParts(tc.versions)
+			for i, v := range tc.start {
+				mm := mp[i].instance.(*mockMigrator)
+				mm.cursor = slices.Index(mm.versions, v)
+			}
+			err := mp.migrateTo(context.Background(), discardLogger(), tc.apply, tc.version)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, c.identifiedVersions)
+		})
+	}
+}
+
+func TestApplyMigrations_Cancelled(t *testing.T) {
+
+	mp, c := newMockMigratorParts([][]uint{{1, 2, 3}, {1, 2, 3}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, p := range mp {
+		mm := p.instance.(*mockMigrator)
+		mm.downstream = func(iv identifiedVersion) {
+			c.collect(iv)
+			if len(c.identifiedVersions) == 2 {
+				cancel()
+			}
+		}
+	}
+
+	err := mp.applyMigrations(ctx, discardLogger(), math.MaxUint)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []identifiedVersion{{0, 1}, {1, 1}}, c.identifiedVersions)
+}
+
+func TestNewMigratorFS(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"order.yaml":                  {Data: []byte("schema_ordering: [first, second]\n")},
+		"0001_01_first_Start.up.sql":  {Data: []byte("CREATE SCHEMA first;\n")},
+		"0001_02_second_Start.up.sql": {Data: []byte("CREATE SCHEMA second;\n")},
+	}
+	m, err := NewMigratorFS(fsys, nil, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, m.Schemata)
+	assert.Equal(t, []string{"0001_02_second_Start.up.sql"}, m.paths["second"])
+}
+
+func TestMigrator_Level(t *testing.T) {
+
+	m := &Migrator{Schemata: []string{"billing", "accounts", "analytics"}}
+	level, err := m.level("analytics")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2}, level)
+
+	err = m.SetDependencies(map[string][]string{"billing": {"accounts"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"accounts", "billing", "analytics"}, m.Schemata)
+	level, err = m.level("billing")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, level)
+	level, err = m.level("analytics")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, level)
+
+	err = m.SetDependencies(map[string][]string{"billing": {"accounts"}, "accounts": {"billing"}})
+	assert.ErrorIs(t, err, ErrDependencyCycle)
+}
@@ -0,0 +1,257 @@
+package multimigrator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexrjones/multimigrator/internal"
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+var (
+	ErrNoMigrations      = errors.New("no migrations directory or filesystem provided")
+	ErrConflictingSource = errors.New("both a migrations directory and a filesystem provided")
+)
+
+// Option configures a Migrator built by New.
+type Option func(*options)
+
+type options struct {
+	rootDir          string
+	fsys             fs.FS
+	schemata         []string
+	dependsOn        map[string][]string
+	logger           *slog.Logger
+	backend          Backend
+	tableName        func(string) string
+	lockTimeout      time.Duration
+	statementTimeout time.Duration
+	hooks            Hooks
+	transactions     TransactionMode
+	templateVars     map[string]string
+}
+
+// WithRootDir reads the migration files from a directory on disk.
+func WithRootDir(rootDir string) Option {
+	return func(o *options) {
+		o.rootDir = rootDir
+	}
+}
+
+// WithFS reads the migration files from fsys rather than a directory on disk,
+// so that migrations can be embedded in the binary with go:embed. The files
+// must be at the root of fsys; use [fs.Sub] for an embedded directory.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.fsys = fsys
+	}
+}
+
+// WithSchemata sets the schema ordering, which is used as given. Without it,
+// the ordering and dependencies are read from the order.yaml at the root of
+// the migrations, which is then required. With it, they're ignored, and only
+// WithDependencies can reorder the schemata. The migrations table name and
+// template variables an order.yaml declares are used either way, unless other
+// options override them. With an ordering given, the order.yaml may be
+// missing, but one that can't be parsed is still an error.
+func WithSchemata(schemata []string) Option {
+	return func(o *options) {
+		o.schemata = schemata
+	}
+}
+
+// WithDependencies sets the schemata each schema depends on, overriding any
+// declared in order.yaml. See Migrator.SetDependencies.
+func WithDependencies(dependsOn map[string][]string) Option {
+	return func(o *options) {
+		o.dependsOn = dependsOn
+	}
+}
+
+// WithLogger logs migrations to a golang-migrate logger, formatting each
+// event as a message followed by its attributes. By default nothing is logged.
+func WithLogger(logger migrate.Logger) Option {
+	return func(o *options) {
+		o.logger = slog.New(&printfHandler{logger: logger})
+	}
+}
+
+// WithSlogLogger logs migrations to logger as structured events. Each step is
+// logged at info level with its schema, version, identifier, direction and
+// duration, and golang-migrate's own messages are logged at debug level.
+func WithSlogLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithBackend sets Migrator.Backend.
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithTableNameFunc sets Migrator.TableName, overriding any migrations table
+// name declared in order.yaml.
+func WithTableNameFunc(tableName func(schema string) string) Option {
+	return func(o *options) {
+		o.tableName = tableName
+	}
+}
+
+// WithLockTimeout sets Migrator.LockTimeout.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithStatementTimeout sets Migrator.StatementTimeout.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = timeout
+	}
+}
+
+// WithTransactions sets Migrator.Transactions.
+func WithTransactions(mode TransactionMode) Option {
+	return func(o *options) {
+		o.transactions = mode
+	}
+}
+
+// WithTemplateVars adds variables for rendering template files, which take
+// precedence over those from order.yaml and the environment.
+func WithTemplateVars(vars map[string]string) Option {
+	return func(o *options) {
+		if o.templateVars == nil {
+			o.templateVars = make(map[string]string)
+		}
+		for k, v := range vars {
+			o.templateVars[k] = v
+		}
+	}
+}
+
+// WithHooks sets Migrator.Hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+func withLog(enableLog bool) Option {
+	if !enableLog {
+		return func(*options) {}
+	}
+	return WithLogger(NewMigrateLogger())
+}
+
+// New returns a Migrator configured by opts. Either WithRootDir or WithFS must
+// be given.
+func New(opts ...Option) (*Migrator, error) {
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	fsys := o.fsys
+	var rootDir string
+	if o.rootDir != "" {
+		if fsys != nil {
+			return nil, ErrConflictingSource
+		}
+		var err error
+		rootDir, err = filepath.Abs(o.rootDir)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Stat(rootDir)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat path %s: %w", rootDir, err)
+		}
+		if !stat.IsDir() {
+			return nil, fmt.Errorf("for path %s: %w", rootDir, internal.ErrNotDirectory)
+		}
+		fsys = os.DirFS(rootDir)
+	}
+	if fsys == nil {
+		return nil, ErrNoMigrations
+	}
+
+	schemata, dependsOn, tableName := o.schemata, o.dependsOn, o.tableName
+	templateVars := make(map[string]string)
+	// order.yaml is read even when the ordering is given, so that every
+	// Migrator of a migrations directory uses the same migrations tables
+	dd, err := internal.ParseMigrationsFS(fsys)
+	if len(schemata) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		schemata = dd.Ordering
+		if dependsOn == nil {
+			dependsOn = dd.DependsOn
+		}
+	} else if errors.Is(err, internal.ErrNoOrderFile) {
+		dd = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if dd != nil {
+		for k, v := range dd.TemplateVars {
+			templateVars[k] = v
+		}
+		if tableName == nil && dd.MigrationsTable != "" {
+			tableName, err = TableNameTemplate(dd.MigrationsTable)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, v := range templateVarsFromEnv() {
+		templateVars[k] = v
+	}
+	for k, v := range o.templateVars {
+		templateVars[k] = v
+	}
+	paths, err := schematadriver.ExpandPathsFS(fsys, schemata)
+	if err != nil {
+		return nil, err
+	}
+	repeatables, err := schematadriver.ExpandRepeatablesFS(fsys, schemata)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		RootDir:          rootDir,
+		Schemata:         schemata,
+		LockTimeout:      o.lockTimeout,
+		Backend:          o.backend,
+		TableName:        tableName,
+		StatementTimeout: o.statementTimeout,
+		Transactions:     o.transactions,
+		TemplateVars:     templateVars,
+		Hooks:            o.hooks,
+		fsys:             fsys,
+		paths:            paths,
+		repeatables:      repeatables,
+		logger:           o.logger,
+	}
+	if len(dependsOn) > 0 {
+		err = m.SetDependencies(dependsOn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,90 @@
+package multimigrator
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"order.yaml": {Data: []byte(`schema_ordering: [first, second]
+depends_on:
+  first: [second]
+migrations_table: "{schema}.schema_migrations"
+`)},
+		"0001_01_first_Start.up.sql":  {Data: []byte("CREATE SCHEMA first;\n")},
+		"0001_02_second_Start.up.sql": {Data: []byte("CREATE SCHEMA second;\n")},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	m, err := New(WithFS(fsys), WithSlogLogger(logger), WithBackend(SQLite), WithLockTimeout(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"second", "first"}, m.Schemata)
+	assert.Equal(t, "first.schema_migrations", m.tableName("first"))
+	assert.Equal(t, logger, m.logger)
+	assert.Equal(t, SQLite, m.Backend)
+	assert.Equal(t, time.Minute, m.LockTimeout)
+
+	// A given ordering is used as given, ignoring order.yaml's dependencies
+	m, err = New(WithFS(fsys), WithSchemata([]string{"first"}), WithTableNameFunc(LegacyTableName))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first"}, m.Schemata)
+	assert.Equal(t, "first_schema_migrations", m.tableName("first"))
+	assert.Nil(t, m.logger)
+	m, err = New(WithFS(fsys), WithSchemata([]string{"first", "second"}), WithDependencies(map[string][]string{"first": {"second"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"second", "first"}, m.Schemata)
+
+	// but order.yaml still sets the migrations table
+	m, err = NewMigratorFS(fsys, []string{"first", "second"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, m.Schemata)
+	assert.Equal(t, "first.schema_migrations", m.tableName("first"))
+
+	// and the ordering is enough without an order.yaml
+	delete(fsys, "order.yaml")
+	m, err = NewMigratorFS(fsys, []string{"first", "second"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "first_schema_migrations", m.tableName("first"))
+	_, err = New(WithFS(fsys))
+	assert.NotNil(t, err)
+
+	_, err = New()
+	assert.ErrorIs(t, err, ErrNoMigrations)
+	_, err = New(WithFS(fsys), WithRootDir("."))
+	assert.ErrorIs(t, err, ErrConflictingSource)
+}
+
+func TestNewMigrator_BrokenOrderFile(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"order.yaml":                  "schema_ordering: [first, second\ndepends_on: {first: [third]}\n",
+		"0001_01_first_Start.up.sql":  "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_02_second_Start.up.sql": "CREATE TABLE second_items (id INTEGER);\n",
+	}
+	for name, body := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644))
+	}
+
+	// The order file is read even when the ordering is given
+	_, err := NewMigrator(dir, []string{"first", "second"}, false)
+	assert.NotNil(t, err)
+	_, err = NewMigrator(dir, nil, false)
+	assert.NotNil(t, err)
+
+	// Without one, the given ordering is enough
+	assert.Nil(t, os.Remove(filepath.Join(dir, "order.yaml")))
+	m, err := NewMigrator(dir, []string{"first", "second"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, m.Schemata)
+	assert.Equal(t, "first_schema_migrations", m.tableName("first"))
+}
@@ -0,0 +1,189 @@
+package multimigrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+var ErrOutOfOrder = errors.New("unapplied migrations are older than the applied version")
+
+// outOfOrder finds the migrations of each part whose version is below the
+// applied version, but that were never applied, such as a file merged late
+// from a branch. A migration counts as applied if its checksum was recorded.
+// Versions below the lowest recorded checksum may have been applied before
+// checksums were recorded, so they're returned as unknown instead, as are all
+// the versions below the applied one of a schema without checksums.
+func (mp migratorParts) outOfOrder(ctx context.Context) (steps, unknown []plannedStep, err error) {
+
+	steps = make([]plannedStep, 0)
+	unknown = make([]plannedStep, 0)
+	for i, p := range mp {
+		applied, _, err := p.instance.Version()
+		if err != nil {
+			if errors.Is(err, migrate.ErrNilVersion) {
+				continue
+			}
+			return nil, nil, err
+		}
+		var recorded map[uint]string
+		if p.checksums != nil {
+			recorded, err = p.checksums.checksums(ctx, p.schema)
+			if err != nil {
+				return nil, nil, fmt.Errorf("while reading checksums for schema %s: %w", p.schema, err)
+			}
+		}
+		var lowest uint
+		first := true
+		for v := range recorded {
+			if first || v < lowest {
+				lowest, first = v, false
+			}
+		}
+		versions, err := p.versions()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range versions {
+			if v >= applied {
+				break
+			}
+			if _, ok := recorded[v]; ok {
+				continue
+			}
+			if len(recorded) > 0 && v > lowest {
+				steps = append(steps, plannedStep{index: i, version: v, outOfOrder: true})
+			} else {
+				unknown = append(unknown, plannedStep{index: i, version: v, outOfOrder: true})
+			}
+		}
+	}
+	sortSteps(steps)
+	sortSteps(unknown)
+
+	return steps, unknown, nil
+}
+
+// checkOutOfOrder returns the migrations that are older than their schema's
+// applied version but were never applied. It fails with ErrOutOfOrder if there
+// are any, unless AllowOutOfOrder is set. Older migrations that can't be told
+// apart from applied ones are logged with a warning, since they may be too.
+func (m *Migrator) checkOutOfOrder(ctx context.Context, logger *slog.Logger, mp migratorParts) ([]plannedStep, error) {
+
+	steps, unknown, err := mp.outOfOrder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		logger.Warn("Can't tell whether migrations older than the applied version were applied, since their checksums weren't recorded", "files", mp.stepFiles(unknown))
+	}
+	if len(steps) == 0 {
+		return steps, nil
+	}
+	files := mp.stepFiles(steps)
+	if !m.AllowOutOfOrder {
+		return nil, fmt.Errorf("%w: %s", ErrOutOfOrder, strings.Join(files, ", "))
+	}
+	logger.Warn("Applying migrations out of order", "files", files)
+	return steps, nil
+}
+
+// stepFiles names the files of steps for logs and errors.
+func (mp migratorParts) stepFiles(steps []plannedStep) []string {
+
+	files := make([]string, len(steps))
+	for i, s := range steps {
+		p := mp[s.index]
+		files[i] = fmt.Sprintf("%s: %d", p.schema, s.version)
+		if mig, err := p.sourceDrv.Migration(s.version, source.Up); err == nil && mig.Raw != "" {
+			files[i] = p.schema + ": " + mig.Raw
+		}
+	}
+	return files
+}
+
+// applyOutOfOrder applies the given out of order migrations, leaving each
+// schema's applied version as it is.
+func (mp migratorParts) applyOutOfOrder(ctx context.Context, logger *slog.Logger, steps []plannedStep) error {
+
+	for _, s := range steps {
+		err := mp[s.index].applyAt(ctx, logger, s.version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAt applies the up migration for version, which is below the part's
+// applied version. The applied version is marked dirty while it runs.
+func (p *migratorPart) applyAt(ctx context.Context, logger *slog.Logger, version uint) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	current, dirty, err := p.instance.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return migrate.ErrDirty{Version: int(current)}
+	}
+	step := StepInfo{Schema: p.schema, Version: version, Direction: source.Up}
+	if mig, err := p.sourceDrv.Migration(version, source.Up); err == nil {
+		step.Identifier = mig.Identifier
+	}
+	exec := p.driverExec()
+	if p.goRunner.has(version) {
+		exec = nil
+	}
+	entry, err := p.runStep(ctx, logger, step, func() error {
+		return p.withDirectives(ctx, p.db, exec, step, func(timeouts string) error {
+			if p.goRunner.has(version) {
+				gm := p.goRunner.migrations[version]
+				return p.goRunner.runAt(ctx, gm, gm.up, int(current), timeouts)
+			}
+			return p.runFile(version, current)
+		})
+	})
+	err = p.finishStep(ctx, entry, err)
+	if err != nil {
+		return err
+	}
+	if p.checksums != nil {
+		sum, err := p.checksum(version)
+		if err != nil {
+			return err
+		}
+		err = p.checksums.record(ctx, p.schema, version, sum)
+		if err != nil {
+			return fmt.Errorf("while recording checksum of version %d for schema %s: %w", version, p.schema, err)
+		}
+	}
+
+	return ctx.Err()
+}
+
+// runFile runs the up file for version without changing the applied version.
+func (p *migratorPart) runFile(version, current uint) error {
+
+	r, _, err := p.sourceDrv.ReadUp(version)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	err = p.driver.SetVersion(int(current), true)
+	if err != nil {
+		return fmt.Errorf("while marking version %d dirty: %w", current, err)
+	}
+	err = p.driver.Run(r)
+	if err != nil {
+		return fmt.Errorf("while running version %d: %w", version, err)
+	}
+	return p.driver.SetVersion(int(current), false)
+}
@@ -0,0 +1,103 @@
+package multimigrator
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUp_OutOfOrder(t *testing.T) {
+
+	fsys := testFS([]string{"orders"}, map[string]string{
+		"20261017120000_01_orders_Start.up.sql":   "CREATE TABLE orders (id INTEGER);\n",
+		"20261017130000_01_orders_AddName.up.sql": "ALTER TABLE orders ADD COLUMN name TEXT;\n",
+	})
+	db := openSQLite(t)
+	err := sqliteMigrator(t, fsys).Up("orders", db)
+	assert.Nil(t, err)
+
+	// A file merged late from a branch
+	fsys["20261017123000_01_orders_AddTotal.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE orders ADD COLUMN total INTEGER;\n")}
+	m := sqliteMigrator(t, fsys)
+	err = m.Up("orders", db)
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+	_, err = m.Plan("orders", db)
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+
+	m.AllowOutOfOrder = true
+	plan, err := m.Plan("orders", db)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlannedMigration{{
+		Schema:     "orders",
+		Version:    20261017123000,
+		Identifier: "01_orders_AddTotal",
+		Path:       "20261017123000_01_orders_AddTotal.up.sql",
+		OutOfOrder: true,
+	}}, plan)
+	err = m.Up("orders", db)
+	assert.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO orders (id, name, total) VALUES (1, 'x', 2)`)
+	assert.Nil(t, err)
+
+	m.AllowOutOfOrder = false
+	err = m.Up("orders", db)
+	assert.Nil(t, err)
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(20261017130000), statuses[0].Version)
+	assert.False(t, statuses[0].Dirty)
+}
+
+func TestUp_OutOfOrder_NoChecksums(t *testing.T) {
+
+	fsys := testFS([]string{"orders"}, map[string]string{
+		"20261017120000_01_orders_Start.up.sql":   "CREATE TABLE orders (id INTEGER);\n",
+		"20261017130000_01_orders_AddName.up.sql": "ALTER TABLE orders ADD COLUMN name TEXT;\n",
+	})
+	db := openSQLite(t)
+	assert.Nil(t, sqliteMigrator(t, fsys).Up("orders", db))
+	// Like a database migrated before checksums were recorded
+	_, err := db.Exec(`DELETE FROM ` + ChecksumTable)
+	assert.Nil(t, err)
+
+	fsys["20261017123000_01_orders_AddTotal.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE orders ADD COLUMN total INTEGER;\n")}
+	var buf bytes.Buffer
+	m := sqliteMigrator(t, fsys, WithSlogLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	plan, err := m.Plan("orders", db)
+	assert.Nil(t, err)
+	assert.Empty(t, plan)
+	assert.Contains(t, buf.String(), "Can't tell whether migrations older than the applied version were applied")
+	assert.Contains(t, buf.String(), "20261017123000_01_orders_AddTotal.up.sql")
+
+	buf.Reset()
+	assert.Nil(t, m.Up("orders", db))
+	assert.Contains(t, buf.String(), "20261017123000_01_orders_AddTotal.up.sql")
+}
+
+func TestUp_OutOfOrder_AfterForce(t *testing.T) {
+
+	fsys := testFS([]string{"orders"}, map[string]string{
+		"0001_01_orders_Start.up.sql": "CREATE TABLE orders (id INTEGER);\n",
+		"0002_01_orders_Bad.up.sql":   "ALTER TABLE nope ADD COLUMN name TEXT;\n",
+	})
+	db := openSQLite(t)
+	m := sqliteMigrator(t, fsys)
+	assert.NotNil(t, m.Up("orders", db))
+	// Fixed up by hand
+	_, err := db.Exec(`ALTER TABLE orders ADD COLUMN name TEXT`)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Force("orders", 2, db))
+
+	fsys["0003_01_orders_AddTotal.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE orders ADD COLUMN total INTEGER;\n")}
+	assert.Nil(t, sqliteMigrator(t, fsys).Up("orders", db))
+	fsys["0004_01_orders_AddNote.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE orders ADD COLUMN note TEXT;\n")}
+	var buf bytes.Buffer
+	m = sqliteMigrator(t, fsys, WithSlogLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	assert.Nil(t, m.Up("orders", db))
+	assert.NotContains(t, buf.String(), "Can't tell whether")
+	_, err = db.Exec(`INSERT INTO orders (id, name, total, note) VALUES (1, 'x', 2, 'y')`)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,94 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// PlannedMigration is a single migration file that Up would run.
+type PlannedMigration struct {
+	Schema     string `json:"schema"`
+	Version    uint   `json:"version"`
+	Identifier string `json:"identifier"`
+	// Path is the file's path on disk, or its path within the filesystem
+	// passed to NewMigratorFS. It's empty for Go migrations.
+	Path string `json:"path"`
+	// OutOfOrder is set for a migration older than its schema's applied
+	// version, which Up only applies if AllowOutOfOrder is set.
+	OutOfOrder bool `json:"out_of_order,omitempty"`
+	// Repeatable is set for a repeatable migration that has changed since it
+	// last ran. Its Version is the schema's version when it runs, and its
+	// Identifier is its file name.
+	Repeatable bool `json:"repeatable,omitempty"`
+}
+
+// Plan returns the migrations Up would run for upToSchema, in the order it
+// would run them, without applying anything to the database. Changed
+// repeatable migrations come after the migrations of their schema level.
+func (m *Migrator) Plan(upToSchema string, db *sql.DB) ([]PlannedMigration, error) {
+	return m.PlanContext(context.Background(), upToSchema, db)
+}
+
+// PlanContext is like Plan, but uses ctx while connecting to the database.
+func (m *Migrator) PlanContext(ctx context.Context, upToSchema string, db *sql.DB) ([]PlannedMigration, error) {
+
+	level, err := m.level(upToSchema)
+	if err != nil {
+		return nil, err
+	}
+	migrators, logger, err := m.openParts(ctx, db, level, false)
+	if err != nil {
+		return nil, err
+	}
+	defer migrators.close()
+	late, err := m.checkOutOfOrder(ctx, logger, migrators)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := migrators.planMigrations(math.MaxUint)
+	if err != nil {
+		return nil, err
+	}
+	planned, err := migrators.plan(m.RootDir, append(slices.Clone(late), steps...))
+	if err != nil {
+		return nil, err
+	}
+	return migrators.planRepeatables(ctx, m.RootDir, steps, planned)
+}
+
+// plan describes the given steps.
+func (mp migratorParts) plan(rootDir string, steps []plannedStep) ([]PlannedMigration, error) {
+
+	ret := make([]PlannedMigration, len(steps))
+	for i, s := range steps {
+		p := mp[s.index]
+		mig, err := p.sourceDrv.Migration(s.version, source.Up)
+		if err != nil {
+			return nil, fmt.Errorf("while reading version %d for schema %s: %w", s.version, p.schema, err)
+		}
+		ret[i] = PlannedMigration{
+			Schema:     p.schema,
+			Version:    s.version,
+			Identifier: mig.Identifier,
+			Path:       migrationPath(rootDir, mig),
+			OutOfOrder: s.outOfOrder,
+		}
+	}
+
+	return ret, nil
+}
+
+// migrationPath returns the path of mig's file, or "" if it has none.
+func migrationPath(rootDir string, mig *source.Migration) string {
+	if mig.Raw == "" {
+		return ""
+	}
+	return filepath.Join(rootDir, mig.Raw)
+}
@@ -0,0 +1,42 @@
+package multimigrator
+
+import (
+	"math"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+
+	mp, c := newMockMigratorParts([][]uint{{1, 2, 3}, {2, 3, 4}})
+	mp[0].instance.(*mockMigrator).cursor = 0
+
+	steps, err := mp.planMigrations(math.MaxUint)
+	assert.Nil(t, err)
+	plan, err := mp.plan("/migrations", steps)
+	assert.Nil(t, err)
+	assert.Empty(t, c.identifiedVersions, "planning must not apply any migrations")
+	assert.Equal(t, []PlannedMigration{
+		{Schema: "schema0", Version: 2, Identifier: "01_schema0_Mock", Path: "/migrations/0002_01_schema0_Mock.up.sql"},
+		{Schema: "schema1", Version: 2, Identifier: "02_schema1_Mock", Path: "/migrations/0002_02_schema1_Mock.up.sql"},
+		{Schema: "schema0", Version: 3, Identifier: "01_schema0_Mock", Path: "/migrations/0003_01_schema0_Mock.up.sql"},
+		{Schema: "schema1", Version: 3, Identifier: "02_schema1_Mock", Path: "/migrations/0003_02_schema1_Mock.up.sql"},
+		{Schema: "schema1", Version: 4, Identifier: "02_schema1_Mock", Path: "/migrations/0004_02_schema1_Mock.up.sql"},
+	}, plan)
+}
+
+func TestPlan_ReadOnly(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":  "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_02_second_Start.up.sql": "CREATE TABLE second_items (id INTEGER);\n",
+		"R_01_first_Views.sql":        "CREATE VIEW first_view AS SELECT id FROM first_items;\n",
+	}))
+	db := openSQLite(t)
+
+	plan, err := m.Plan("second", db)
+	assert.Nil(t, err)
+	assert.Len(t, plan, 3)
+	assert.Empty(t, sqliteTables(t, db), "planning must not create any tables")
+}
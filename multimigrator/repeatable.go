@@ -0,0 +1,305 @@
+package multimigrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// Repeatable migrations are files named like R_02_billing_Views.sql, or
+// R_02_billing_Views.sql.tmpl for a template, that recreate objects such as
+// views, functions and grants. Once a schema and every schema ordered before
+// it have no versioned migrations left to apply, Up and Goto run the schema's
+// repeatable migrations whose contents have changed since they last ran, in
+// order of file name, and record their checksums in RepeatableTable. They're
+// never reverted, but reverting every migration of a schema forgets its
+// checksums so that the next Up runs them again. In Up's transaction modes,
+// they run in the transaction of the migrations before them.
+const RepeatableTable = "multimigrator_repeatables"
+
+type repeatableStore interface {
+	checksums(ctx context.Context, schema string) (map[string]string, error)
+	record(ctx context.Context, schema, name, checksum string) error
+	forget(ctx context.Context, schema string) error
+}
+
+type sqlRepeatableStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func newRepeatableStore(ctx context.Context, db *sql.DB, dialect Dialect) (*sqlRepeatableStore, error) {
+
+	query := `CREATE TABLE IF NOT EXISTS ` + RepeatableTable + ` (
+	schema_name ` + dialect.Text + ` NOT NULL,
+	name ` + dialect.Text + ` NOT NULL,
+	checksum ` + dialect.Text + ` NOT NULL,
+	applied_at ` + dialect.Timestamp + ` NOT NULL,
+	PRIMARY KEY (schema_name, name)
+)`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, fmt.Errorf("while creating %s: %w", RepeatableTable, err)
+	}
+	return &sqlRepeatableStore{db: db, dialect: dialect}, nil
+}
+
+// openRepeatableStore returns the repeatable migrations' store for a run, or
+// nil if there are no repeatable migrations. Runs that change the database
+// create its table; for the others a missing table means that no repeatable
+// migrations have run, and the store is nil.
+func (m *Migrator) openRepeatableStore(ctx context.Context, db *sql.DB, write bool) (repeatableStore, error) {
+
+	if len(m.repeatables) == 0 {
+		return nil, nil
+	}
+	dialect := m.backend().Dialect()
+	if write {
+		return newRepeatableStore(ctx, db, dialect)
+	}
+	exists, err := m.backend().TableExists(ctx, db, RepeatableTable)
+	if err != nil {
+		return nil, fmt.Errorf("while checking for %s: %w", RepeatableTable, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return &sqlRepeatableStore{db: db, dialect: dialect}, nil
+}
+
+func (s *sqlRepeatableStore) checksums(ctx context.Context, schema string) (map[string]string, error) {
+
+	p := s.dialect.Placeholder
+	rows, err := s.db.QueryContext(ctx, `SELECT name, checksum FROM `+RepeatableTable+` WHERE schema_name = `+p(1), schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		ret[name] = checksum
+	}
+	return ret, rows.Err()
+}
+
+func (s *sqlRepeatableStore) record(ctx context.Context, schema, name, checksum string) error {
+
+	return replaceRow(ctx, s.db, s.dialect, RepeatableTable, 2, []string{"schema_name", "name", "checksum", "applied_at"},
+		schema, name, checksum, time.Now().UTC())
+}
+
+func (s *sqlRepeatableStore) forget(ctx context.Context, schema string) error {
+
+	p := s.dialect.Placeholder
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+RepeatableTable+` WHERE schema_name = `+p(1), schema)
+	return err
+}
+
+// repeatable is a repeatable migration file and its rendered contents.
+type repeatable struct {
+	name     string
+	body     []byte
+	checksum string
+}
+
+// readRepeatables reads the schema's repeatable migrations in order of file
+// name, rendering any templates.
+func (m *Migrator) readRepeatables(schema string) ([]repeatable, error) {
+
+	names := slices.Clone(m.repeatables[schema])
+	slices.Sort(names)
+	ret := make([]repeatable, 0, len(names))
+	for _, n := range names {
+		body, err := fs.ReadFile(m.fsys, n)
+		if err != nil {
+			return nil, err
+		}
+		if schematadriver.IsTemplate(n) {
+			body, err = schematadriver.RenderTemplate(n, body, m.TemplateVars)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sum := sha256.Sum256(body)
+		ret = append(ret, repeatable{name: n, body: body, checksum: hex.EncodeToString(sum[:])})
+	}
+	return ret, nil
+}
+
+// levelsDone returns, for the start of steps and after each of them, the
+// indices of the parts whose schema level is fully migrated at that point, so
+// that their repeatable migrations can run. A part's level is done once it and
+// every part ordered before it have no steps left.
+func (mp migratorParts) levelsDone(steps []plannedStep) [][]int {
+
+	done := make([][]int, len(steps)+1)
+	end := 0
+	for i := range mp {
+		for j, s := range steps {
+			if s.index == i && j+1 > end {
+				end = j + 1
+			}
+		}
+		done[end] = append(done[end], i)
+	}
+	return done
+}
+
+// versionAfter returns the version the part at index is at once steps have
+// run, and whether it has one.
+func (mp migratorParts) versionAfter(index int, steps []plannedStep) (uint, bool, error) {
+
+	version, _, err := mp[index].instance.Version()
+	hasVersion := err == nil
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, err
+	}
+	for _, s := range steps {
+		if s.index == index && (!hasVersion || s.version > version) {
+			version, hasVersion = s.version, true
+		}
+	}
+	return version, hasVersion, nil
+}
+
+// hasRepeatables reports whether any of the parts has repeatable migrations.
+func (mp migratorParts) hasRepeatables() bool {
+	return slices.ContainsFunc(mp, func(p *migratorPart) bool {
+		return len(p.repeatables) > 0
+	})
+}
+
+// applyRepeatables runs the part's changed repeatable migrations if it has a
+// version applied, and returns how many ran.
+func (p *migratorPart) applyRepeatables(ctx context.Context, logger *slog.Logger) (int, error) {
+
+	if len(p.repeatables) == 0 {
+		return 0, nil
+	}
+	version, dirty, err := p.instance.Version()
+	if err != nil {
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if dirty {
+		return 0, migrate.ErrDirty{Version: int(version)}
+	}
+	changed, err := p.changedRepeatables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, r := range changed {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		step := StepInfo{Schema: p.schema, Version: version, Identifier: r.name, Direction: source.Up}
+		entry, err := p.runStep(ctx, logger, step, func() error {
+			return p.driver.Run(bytes.NewReader(r.body))
+		})
+		err = p.finishStep(ctx, entry, err)
+		if err != nil {
+			return i, err
+		}
+		err = p.repeatableStore.record(ctx, p.schema, r.name, r.checksum)
+		if err != nil {
+			return i, fmt.Errorf("while recording checksum of %s for schema %s: %w", r.name, p.schema, err)
+		}
+	}
+	return len(changed), nil
+}
+
+// changedRepeatables returns the part's repeatable migrations whose contents
+// have changed since they last ran, in the order they run.
+func (p *migratorPart) changedRepeatables(ctx context.Context) ([]repeatable, error) {
+
+	recorded := make(map[string]string)
+	if p.repeatableStore != nil {
+		var err error
+		recorded, err = p.repeatableStore.checksums(ctx, p.schema)
+		if err != nil {
+			return nil, fmt.Errorf("while reading repeatable checksums for schema %s: %w", p.schema, err)
+		}
+	}
+	ret := make([]repeatable, 0)
+	for _, r := range p.repeatables {
+		if recorded[r.name] != r.checksum {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// planRepeatables adds the repeatable migrations Up would run to planned,
+// which describes the out of order steps followed by steps, for each part
+// that would have a version applied. They're placed after the step that
+// finishes their schema level, like applyMigrations runs them.
+func (mp migratorParts) planRepeatables(ctx context.Context, rootDir string, steps []plannedStep, planned []PlannedMigration) ([]PlannedMigration, error) {
+
+	late := len(planned) - len(steps)
+	ret := slices.Clone(planned[:late])
+	for i, indices := range mp.levelsDone(steps) {
+		if i > 0 {
+			ret = append(ret, planned[late+i-1])
+		}
+		for _, index := range indices {
+			p := mp[index]
+			if len(p.repeatables) == 0 {
+				continue
+			}
+			version, hasVersion, err := mp.versionAfter(index, steps)
+			if err != nil {
+				return nil, err
+			}
+			if !hasVersion {
+				continue
+			}
+			changed, err := p.changedRepeatables(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range changed {
+				ret = append(ret, PlannedMigration{
+					Schema:     p.schema,
+					Version:    version,
+					Identifier: r.name,
+					Path:       filepath.Join(rootDir, r.name),
+					Repeatable: true,
+				})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// forgetRepeatables forgets the checksums of the part's repeatable migrations,
+// so that they run again once its versioned migrations are reapplied.
+func (p *migratorPart) forgetRepeatables(ctx context.Context) error {
+
+	if p.repeatableStore == nil {
+		return nil
+	}
+	err := p.repeatableStore.forget(ctx, p.schema)
+	if err != nil {
+		return fmt.Errorf("while forgetting repeatable checksums for schema %s: %w", p.schema, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package multimigrator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUp_Repeatables(t *testing.T) {
+
+	fsys := testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":   "CREATE TABLE runs (name TEXT);\n",
+		"0001_01_first_Start.down.sql": "DROP TABLE runs;\n",
+		"0001_02_second_Start.up.sql":  "CREATE TABLE second (id INTEGER);\n",
+		"R_01_first_B.sql":             "INSERT INTO runs VALUES ('b');\n",
+		"R_01_first_A.sql":             "INSERT INTO runs VALUES ('a');\n",
+		"R_02_second_Views.sql":        "DROP VIEW IF EXISTS second_view; CREATE VIEW second_view AS SELECT id FROM second;\n",
+		// Only repeatables at the root are read, as Validate reports
+		"nested/R_01_first_C.sql": "INSERT INTO runs VALUES ('c');\n",
+	})
+	db := openSQLite(t)
+	runs := func() []string {
+		rows, err := db.Query(`SELECT name FROM runs`)
+		assert.Nil(t, err)
+		defer rows.Close()
+		ret := make([]string, 0)
+		for rows.Next() {
+			var name string
+			assert.Nil(t, rows.Scan(&name))
+			ret = append(ret, name)
+		}
+		return ret
+	}
+
+	planned := func(m *Migrator, level string) []string {
+		plan, err := m.Plan(level, db)
+		assert.Nil(t, err)
+		ret := make([]string, 0)
+		for _, pm := range plan {
+			if pm.Repeatable {
+				ret = append(ret, fmt.Sprintf("%s@%d", pm.Identifier, pm.Version))
+			}
+		}
+		return ret
+	}
+
+	// Only the level's schemata run their repeatable migrations, in order of name
+	m := sqliteMigrator(t, fsys)
+	assert.Equal(t, []string{"R_01_first_A.sql@1", "R_01_first_B.sql@1"}, planned(m, "first"))
+	exists, err := SQLite.TableExists(context.Background(), db, RepeatableTable)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, m.Up("first", db))
+	assert.Equal(t, []string{"a", "b"}, runs())
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'second_view'`).Scan(&count))
+	assert.Zero(t, count)
+
+	// Unchanged repeatable migrations don't run again
+	assert.Equal(t, []string{"R_02_second_Views.sql@1"}, planned(m, "second"))
+	assert.Nil(t, m.Up("second", db))
+	assert.Equal(t, []string{"a", "b"}, runs())
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'second_view'`).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	// Changed ones do
+	fsys["R_01_first_B.sql"] = &fstest.MapFile{Data: []byte("INSERT INTO runs VALUES ('b2');\n")}
+	m = sqliteMigrator(t, fsys)
+	assert.Equal(t, []string{"R_01_first_B.sql@1"}, planned(m, "second"))
+	assert.Nil(t, m.Up("second", db))
+	assert.Equal(t, []string{"a", "b", "b2"}, runs())
+	assert.Empty(t, planned(m, "second"))
+
+	// Reverting a schema entirely makes them run again
+	assert.Nil(t, m.Down("", db))
+	assert.Nil(t, m.Up("first", db))
+	assert.Equal(t, []string{"a", "b2"}, runs())
+}
+
+func TestUp_RepeatableFails(t *testing.T) {
+
+	fsys := testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql": "CREATE TABLE t (id INTEGER);\n",
+		"R_01_first_Views.sql":       "CREATE VIEW v AS SELEC id FROM t;\n",
+	})
+	db := openSQLite(t)
+	assert.NotNil(t, sqliteMigrator(t, fsys).Up("first", db))
+
+	// The versioned migration stays applied and the repeatable one runs again
+	fsys["R_01_first_Views.sql"] = &fstest.MapFile{Data: []byte("CREATE VIEW v AS SELECT id FROM t;\n")}
+	assert.Nil(t, sqliteMigrator(t, fsys).Up("first", db))
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM `+RepeatableTable+` WHERE schema_name = 'first'`).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestUp_RepeatablesPerLevel(t *testing.T) {
+
+	// second's version 3 reads the view that first's repeatable migration
+	// creates once first is done
+	fsys := testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":   "CREATE TABLE items (id INTEGER);\n",
+		"0002_01_first_AddName.up.sql": "ALTER TABLE items ADD COLUMN name TEXT;\n",
+		"R_01_first_Views.sql":         "DROP VIEW IF EXISTS item_names; CREATE VIEW item_names AS SELECT name FROM items;\n",
+		"0001_02_second_Start.up.sql":  "CREATE TABLE second (id INTEGER);\n",
+		"0003_02_second_Report.up.sql": "CREATE TABLE report AS SELECT name FROM item_names;\n",
+	})
+	db := openSQLite(t)
+	m := sqliteMigrator(t, fsys)
+
+	plan, err := m.Plan("second", db)
+	assert.Nil(t, err)
+	order := make([]string, len(plan))
+	for i, pm := range plan {
+		order[i] = fmt.Sprintf("%s@%d", pm.Identifier, pm.Version)
+	}
+	assert.Equal(t, []string{"01_first_Start@1", "02_second_Start@1", "01_first_AddName@2", "R_01_first_Views.sql@2", "02_second_Report@3"}, order)
+	assert.Nil(t, m.Up("second", db))
+
+	// Goto runs them too
+	db = openSQLite(t)
+	assert.Nil(t, m.Goto("second", 2, db))
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'item_names'`).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,159 @@
+package multimigrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidMigrationName = errors.New("migration name must only contain letters, digits and underscores")
+	// ErrReadOnlySource is returned when creating files for a Migrator that
+	// reads its migrations from an fs.FS rather than a directory.
+	ErrReadOnlySource = errors.New("migrations aren't read from a directory")
+)
+
+// VersionScheme is how CreateMigration numbers a new migration.
+type VersionScheme string
+
+const (
+	// SequentialVersions numbers a new migration one after the highest version
+	// of any schema.
+	SequentialVersions VersionScheme = "sequential"
+	// TimestampVersions numbers a new migration with the current UTC time, as
+	// in 20240131235959.
+	TimestampVersions VersionScheme = "timestamp"
+)
+
+const (
+	timestampVersionLayout = "20060102150405"
+	minVersionWidth        = 4
+	minIndexWidth          = 2
+)
+
+var migrationIdentifierRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// MigrationFiles are the names of a new migration's files, relative to the
+// migrations directory.
+type MigrationFiles struct {
+	Version uint   `json:"version"`
+	Up      string `json:"up"`
+	Down    string `json:"down"`
+}
+
+// CreateMigration creates empty up and down files for a new migration of
+// schema in the migrations directory.
+func (m *Migrator) CreateMigration(schema, name string, scheme VersionScheme) (MigrationFiles, error) {
+
+	if m.RootDir == "" {
+		return MigrationFiles{}, ErrReadOnlySource
+	}
+	files, err := m.newMigrationFiles(schema, name, scheme, time.Now())
+	if err != nil {
+		return MigrationFiles{}, err
+	}
+	up := filepath.Join(m.RootDir, files.Up)
+	err = writeNewFile(up, fmt.Sprintf("-- %s\n", files.Up))
+	if err != nil {
+		return MigrationFiles{}, err
+	}
+	err = writeNewFile(filepath.Join(m.RootDir, files.Down), fmt.Sprintf("-- %s\n", files.Down))
+	if err != nil {
+		os.Remove(up)
+		return MigrationFiles{}, err
+	}
+	return files, nil
+}
+
+// newMigrationFiles names the files of a new migration, reusing the schema's
+// index segment and the widths of the existing files' segments.
+func (m *Migrator) newMigrationFiles(schema, name string, scheme VersionScheme, now time.Time) (MigrationFiles, error) {
+
+	position, ok := findSchema(schema, m.Schemata)
+	if !ok {
+		return MigrationFiles{}, fmt.Errorf("couldn't find schema %s: %w", schema, ErrNoSchema)
+	}
+	// Schemata are found regardless of case, but files must use the ordering's case
+	schema = m.Schemata[position]
+	if !migrationIdentifierRegex.MatchString(name) {
+		return MigrationFiles{}, fmt.Errorf("for name %q: %w", name, ErrInvalidMigrationName)
+	}
+
+	var highest, maxIndex uint64
+	var index string
+	versionWidth := minVersionWidth
+	indexes := make(map[string]bool)
+	for s, names := range m.paths {
+		for _, n := range names {
+			parts := migrationNameRegex.FindStringSubmatch(n)
+			if parts == nil {
+				continue
+			}
+			version, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			highest = max(highest, version)
+			versionWidth = max(versionWidth, len(parts[1]))
+			indexes[parts[2]] = true
+			if i, err := strconv.ParseUint(parts[2], 10, 64); err == nil {
+				maxIndex = max(maxIndex, i)
+			}
+			if s == schema && index == "" {
+				index = parts[2]
+			}
+		}
+	}
+	for _, s := range m.Schemata {
+		for version := range registeredGoMigrations(s) {
+			highest = max(highest, uint64(version))
+		}
+	}
+	if index == "" {
+		// A schema without files takes its position in the ordering, unless
+		// another schema's files already use it
+		index = fmt.Sprintf("%0*d", minIndexWidth, position+1)
+		if indexes[index] {
+			index = fmt.Sprintf("%0*d", minIndexWidth, maxIndex+1)
+		}
+	}
+
+	var version uint64
+	switch scheme {
+	case SequentialVersions, "":
+		version = highest + 1
+	case TimestampVersions:
+		var err error
+		version, err = strconv.ParseUint(now.UTC().Format(timestampVersionLayout), 10, 64)
+		if err != nil {
+			return MigrationFiles{}, err
+		}
+	default:
+		return MigrationFiles{}, fmt.Errorf("unknown version scheme %q", scheme)
+	}
+
+	base := fmt.Sprintf("%0*d_%s_%s_%s", versionWidth, version, index, schema, name)
+	return MigrationFiles{
+		Version: uint(version),
+		Up:      base + ".up.sql",
+		Down:    base + ".down.sql",
+	}, nil
+}
+
+// writeNewFile writes contents to path, failing if it already exists.
+func writeNewFile(path, contents string) error {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
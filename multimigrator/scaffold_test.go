@@ -0,0 +1,122 @@
+package multimigrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestNewMigrationFiles(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"order.yaml":                    {Data: []byte("schema_ordering: [first, second, third]\n")},
+		"0001_01_first_Start.up.sql":    {},
+		"0001_01_first_Start.down.sql":  {},
+		"0007_03_second_Start.up.sql":   {},
+		"0007_03_second_Start.down.sql": {},
+		"00002_01_first_Amend.up.sql":   {},
+		"00002_01_first_Amend.down.sql": {},
+	}
+	m, err := NewMigratorFS(fsys, nil, false)
+	assert.Nil(t, err)
+	now := time.Date(2024, 1, 31, 23, 59, 59, 0, time.FixedZone("", 3600))
+
+	type testCase struct {
+		name     string
+		schema   string
+		migName  string
+		scheme   VersionScheme
+		expected string
+		err      error
+	}
+	tcs := []testCase{
+		{
+			name:     "Next version after the highest of any schema",
+			schema:   "first",
+			migName:  "AddInvoices",
+			scheme:   SequentialVersions,
+			expected: "00008_01_first_AddInvoices",
+		},
+		{
+			name:     "Index is reused from the schema's files",
+			schema:   "second",
+			migName:  "AddInvoices",
+			expected: "00008_03_second_AddInvoices",
+		},
+		{
+			name:     "Schema without files takes an unused index",
+			schema:   "third",
+			migName:  "Start",
+			expected: "00008_04_third_Start",
+		},
+		{
+			name:     "Schema name in another case uses the ordering's",
+			schema:   "Second",
+			migName:  "AddInvoices",
+			expected: "00008_03_second_AddInvoices",
+		},
+		{
+			name:     "Schema without files in another case uses the ordering's",
+			schema:   "THIRD",
+			migName:  "Start",
+			expected: "00008_04_third_Start",
+		},
+		{
+			name:     "Timestamp version is in UTC",
+			schema:   "first",
+			migName:  "AddInvoices",
+			scheme:   TimestampVersions,
+			expected: "20240131225959_01_first_AddInvoices",
+		},
+		{
+			name:    "Unknown schema",
+			schema:  "fourth",
+			migName: "Start",
+			err:     ErrNoSchema,
+		},
+		{
+			name:    "Invalid name",
+			schema:  "first",
+			migName: "Add.Invoices",
+			err:     ErrInvalidMigrationName,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := m.newMigrationFiles(tc.schema, tc.migName, tc.scheme, now)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected+".up.sql", files.Up)
+			assert.Equal(t, tc.expected+".down.sql", files.Down)
+		})
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "order.yaml"), []byte("schema_ordering: [billing]\n"), 0o644)
+	assert.Nil(t, err)
+	m, err := New(WithRootDir(dir))
+	assert.Nil(t, err)
+
+	files, err := m.CreateMigration("billing", "Start", SequentialVersions)
+	assert.Nil(t, err)
+	assert.Equal(t, "0001_01_billing_Start.up.sql", files.Up)
+	for _, f := range []string{files.Up, files.Down} {
+		_, err = os.Stat(filepath.Join(dir, f))
+		assert.Nil(t, err)
+	}
+
+	m, err = New(WithFS(os.DirFS(dir)))
+	assert.Nil(t, err)
+	_, err = m.CreateMigration("billing", "Start", SequentialVersions)
+	assert.ErrorIs(t, err, ErrReadOnlySource)
+}
@@ -0,0 +1,137 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// SchemaStatus describes the state of a single schema in a database.
+type SchemaStatus struct {
+	Schema string `json:"schema"`
+	// Version is the currently applied version, valid only when Applied is true.
+	Version uint `json:"version"`
+	Applied bool `json:"applied"`
+	Dirty   bool `json:"dirty"`
+	// Pending lists the versions on disk that are newer than Version.
+	Pending []uint `json:"pending"`
+	// Missing lists applied versions that have no migration file on disk:
+	// Version, and any older version whose checksum was recorded when it was
+	// applied. Older versions applied before checksums were recorded aren't
+	// known, so they're never listed.
+	Missing []uint `json:"missing"`
+	// Drifted lists the files of applied versions that have changed on disk
+	// since they were applied.
+	Drifted []string `json:"drifted"`
+}
+
+// Status reports the applied and pending migrations of every schema.
+func (m *Migrator) Status(db *sql.DB) ([]SchemaStatus, error) {
+	return m.StatusContext(context.Background(), db)
+}
+
+// StatusContext is like Status, but uses ctx while connecting to the database.
+func (m *Migrator) StatusContext(ctx context.Context, db *sql.DB) ([]SchemaStatus, error) {
+
+	migrators, _, err := m.openParts(ctx, db, schemaRange(0, len(m.Schemata)), false)
+	if err != nil {
+		return nil, err
+	}
+	defer migrators.close()
+
+	return migrators.status(ctx)
+}
+
+func (mp migratorParts) status(ctx context.Context) ([]SchemaStatus, error) {
+
+	drifted, err := mp.drift(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]SchemaStatus, 0, len(mp))
+	for _, p := range mp {
+		st := SchemaStatus{
+			Schema:  p.schema,
+			Pending: make([]uint, 0),
+			Missing: make([]uint, 0),
+			Drifted: make([]string, 0),
+		}
+		for _, d := range drifted {
+			if d.Schema == p.schema {
+				st.Drifted = append(st.Drifted, d.File)
+			}
+		}
+		var err error
+		st.Version, st.Dirty, err = p.instance.Version()
+		if err != nil {
+			if !errors.Is(err, migrate.ErrNilVersion) {
+				return nil, err
+			}
+		} else {
+			st.Applied = true
+		}
+		versions, err := p.versions()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if !st.Applied || v > st.Version {
+				st.Pending = append(st.Pending, v)
+			}
+		}
+		if st.Applied {
+			st.Missing, err = p.missing(ctx, st.Version, versions)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, st)
+	}
+
+	return ret, nil
+}
+
+// missing lists the applied versions up to and including version that aren't
+// in versions, the versions available from the part's source.
+func (p *migratorPart) missing(ctx context.Context, version uint, versions []uint) ([]uint, error) {
+
+	ret := make([]uint, 0)
+	if p.checksums != nil {
+		recorded, err := p.checksums.checksums(ctx, p.schema)
+		if err != nil {
+			return nil, fmt.Errorf("while reading checksums for schema %s: %w", p.schema, err)
+		}
+		for v := range recorded {
+			if v < version && !slices.Contains(versions, v) {
+				ret = append(ret, v)
+			}
+		}
+		slices.Sort(ret)
+	}
+	if !slices.Contains(versions, version) {
+		ret = append(ret, version)
+	}
+	return ret, nil
+}
+
+// versions lists every version available from the part's source, in order.
+func (p *migratorPart) versions() ([]uint, error) {
+
+	ret := []uint{p.firstVersion}
+	for {
+		next, err := p.sourceDrv.Next(ret[len(ret)-1])
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return ret, nil
+			}
+			return nil, err
+		}
+		ret = append(ret, next)
+	}
+}
@@ -0,0 +1,58 @@
+package multimigrator
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+
+	mp, _ := newMockMigratorParts([][]uint{{1, 2, 3}, {2, 3, 4}, {800}})
+	mp[0].instance.(*mockMigrator).cursor = 1
+	// Simulate a database migrated by a version of the files that is no longer on disk
+	mm := mp[1].instance.(*mockMigrator)
+	mm.cursor = slices.Index(mm.versions, 3)
+	mp[1].sourceDrv = &mockMigrator{versions: []uint{2, 4}}
+	// whose older versions are only known to have been applied by their checksums
+	store := newMockChecksumStore()
+	for _, v := range []uint{1, 3} {
+		assert.Nil(t, store.record(context.Background(), "schema1", v, "sum"))
+	}
+	mp[1].checksums = store
+
+	st, err := mp.status(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []SchemaStatus{
+		{Schema: "schema0", Version: 2, Applied: true, Pending: []uint{3}, Missing: []uint{}, Drifted: []string{}},
+		{Schema: "schema1", Version: 3, Applied: true, Pending: []uint{4}, Missing: []uint{1, 3}, Drifted: []string{}},
+		{Schema: "schema2", Pending: []uint{800}, Missing: []uint{}, Drifted: []string{}},
+	}, st)
+}
+
+func TestStatus_ReadOnly(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first", "second"}, map[string]string{
+		"0001_01_first_Start.up.sql":  "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_02_second_Start.up.sql": "CREATE TABLE second_items (id INTEGER);\n",
+	}))
+	m.TableName = func(schema string) string { return schema + "_versions" }
+	db := openSQLite(t)
+
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	for _, st := range statuses {
+		assert.False(t, st.Applied, st.Schema)
+		assert.Equal(t, []uint{1}, st.Pending, st.Schema)
+	}
+	assert.Empty(t, sqliteTables(t, db))
+
+	assert.Nil(t, m.Up("first", db))
+	statuses, err = m.Status(db)
+	assert.Nil(t, err)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+	assert.NotContains(t, sqliteTables(t, db), "second_versions")
+}
@@ -0,0 +1,197 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/alexrjones/multimigrator/internal"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// defaultMigrationsTable is appended to a schema's name to get the table that
+// records its version.
+const defaultMigrationsTable = "schema_migrations"
+
+// adoptedSuffix is appended to the name of a legacy migrations table once its
+// version has been copied to the configured table.
+const adoptedSuffix = "_adopted"
+
+// errReadOnly is returned when a part opened for a run that doesn't change the
+// database is asked to.
+var errReadOnly = errors.New("schema was opened read-only")
+
+// SchemaPlaceholder is replaced by the schema name in a TableNameTemplate.
+const SchemaPlaceholder = internal.SchemaPlaceholder
+
+// ErrInvalidTableName is returned when a table name template doesn't contain
+// SchemaPlaceholder.
+var ErrInvalidTableName = internal.ErrInvalidTableName
+
+// LegacyTableName is the default migrations table name: a table named after
+// the schema, in the database's current schema.
+func LegacyTableName(schema string) string {
+	return schema + "_" + defaultMigrationsTable
+}
+
+// TableNameTemplate returns a function for Migrator.TableName that replaces
+// SchemaPlaceholder in tmpl with the schema name. A name may be qualified with
+// the Postgres schema to keep the table in, which is created if it's missing:
+// "{schema}.schema_migrations" keeps each table inside its own schema, so the
+// schema's first migration must use CREATE SCHEMA IF NOT EXISTS, and
+// "migrations.{schema}" keeps them all in a dedicated schema.
+func TableNameTemplate(tmpl string) (func(string) string, error) {
+
+	if !strings.Contains(tmpl, SchemaPlaceholder) {
+		return nil, fmt.Errorf("for template %q: %w", tmpl, ErrInvalidTableName)
+	}
+	return func(schema string) string {
+		return strings.ReplaceAll(tmpl, SchemaPlaceholder, schema)
+	}, nil
+}
+
+func (m *Migrator) tableName(schema string) string {
+	if m.TableName == nil {
+		return LegacyTableName(schema)
+	}
+	return m.TableName(schema)
+}
+
+// legacyPending reports whether a schema's legacy migrations table is waiting
+// to be adopted by table: it exists, and table doesn't record a version yet.
+func (m *Migrator) legacyPending(ctx context.Context, db *sql.DB, logger *slog.Logger, schema, table string) (bool, error) {
+
+	legacy := LegacyTableName(schema)
+	if table == legacy {
+		return false, nil
+	}
+	backend := m.backend()
+	exists, err := backend.TableExists(ctx, db, legacy)
+	if err != nil || !exists {
+		return false, err
+	}
+	exists, err = backend.TableExists(ctx, db, table)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return true, nil
+	}
+	var version int64
+	err = db.QueryRowContext(ctx, `SELECT version FROM `+quoteTable(backend.Dialect(), table)+` LIMIT 1`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("while reading %s: %w", table, err)
+	}
+	logger.Warn("Not adopting legacy migrations table, since its replacement already records a version", "schema", schema, "legacy_table", legacy, "table", table, "version", version)
+	return false, nil
+}
+
+// adoptLegacyTable copies the version recorded in a schema's legacy migrations
+// table into table, so that databases migrated before the table was renamed
+// keep their state. The legacy table is renamed with adoptedSuffix rather than
+// dropped, so that an older release can still be pointed back at it.
+func (m *Migrator) adoptLegacyTable(ctx context.Context, db *sql.DB, driver database.Driver, logger *slog.Logger, schema, table string) error {
+
+	legacy := LegacyTableName(schema)
+	dialect := m.backend().Dialect()
+	quoted := quoteTable(dialect, legacy)
+	var legacyVersion int64
+	var dirty bool
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM `+quoted+` LIMIT 1`).Scan(&legacyVersion, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("while reading %s: %w", legacy, err)
+	}
+	if err == nil {
+		err = driver.SetVersion(int(legacyVersion), dirty)
+		if err != nil {
+			return fmt.Errorf("while copying version from %s: %w", legacy, err)
+		}
+	}
+	_, err = db.ExecContext(ctx, `ALTER TABLE `+quoted+` RENAME TO `+dialect.Quote(legacy+adoptedSuffix))
+	if err != nil {
+		return fmt.Errorf("while renaming %s: %w", legacy, err)
+	}
+	logger.Info("Adopted legacy migrations table", "schema", schema, "version", legacyVersion, "legacy_table", legacy, "table", table, "renamed_to", legacy+adoptedSuffix)
+	return nil
+}
+
+// readVersion reads the version recorded in a migrations table with q, without
+// creating the table. ok is false if the table doesn't exist or records no
+// version.
+func (m *Migrator) readVersion(ctx context.Context, db *sql.DB, q querier, table string) (version int64, dirty, ok bool, err error) {
+
+	backend := m.backend()
+	exists, err := backend.TableExists(ctx, db, table)
+	if err != nil || !exists {
+		return 0, false, false, err
+	}
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM `+quoteTable(backend.Dialect(), table)+` LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, false, nil
+	}
+	if err != nil {
+		return 0, false, false, fmt.Errorf("while reading %s: %w", table, err)
+	}
+	return version, dirty, true, nil
+}
+
+// tableVersion reads a schema's version straight from its migrations table,
+// for runs that don't change the database. Opening a golang-migrate driver
+// would create the table, and the schema qualifying it.
+type tableVersion struct {
+	// ctx is the context of the run the schema was opened for
+	ctx   context.Context
+	m     *Migrator
+	db    *sql.DB
+	table string
+}
+
+func (t *tableVersion) Version() (uint, bool, error) {
+
+	version, dirty, ok, err := t.m.readVersion(t.ctx, t.db, t.db, t.table)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok || version < 0 {
+		return 0, false, migrate.ErrNilVersion
+	}
+	return uint(version), dirty, nil
+}
+
+func (t *tableVersion) Steps(int) error {
+	return errReadOnly
+}
+
+func (t *tableVersion) Force(int) error {
+	return errReadOnly
+}
+
+// execer runs statements on a database, connection or transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// writeVersion records version in a migrations table with e, the way
+// golang-migrate's drivers do, so that migrations can record their version in
+// their own transaction. A clean nil version leaves the table empty.
+func writeVersion(ctx context.Context, e execer, dialect Dialect, table string, version int, dirty bool) error {
+
+	quoted := quoteTable(dialect, table)
+	_, err := e.ExecContext(ctx, `DELETE FROM `+quoted)
+	if err != nil {
+		return err
+	}
+	if version < 0 && !dirty {
+		return nil
+	}
+	q := dialect.Placeholder
+	_, err = e.ExecContext(ctx, `INSERT INTO `+quoted+` (version, dirty) VALUES (`+q(1)+`, `+q(2)+`)`, int64(version), dirty)
+	return err
+}
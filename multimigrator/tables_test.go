@@ -0,0 +1,85 @@
+package multimigrator
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestTableNameTemplate(t *testing.T) {
+
+	type testCase struct {
+		template string
+		expected string
+		err      error
+	}
+	for _, tc := range []testCase{
+		{template: "{schema}.schema_migrations", expected: "billing.schema_migrations"},
+		{template: "migrations.{schema}", expected: "migrations.billing"},
+		{template: "schema_migrations", err: ErrInvalidTableName},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			tableName, err := TableNameTemplate(tc.template)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, tableName("billing"))
+		})
+	}
+}
+
+func TestAdoptLegacyTable(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"0001_01_first_Start.up.sql":   {Data: []byte("CREATE TABLE first_items (id INTEGER);\n")},
+		"0001_01_first_Start.down.sql": {Data: []byte("DROP TABLE first_items;\n")},
+		"0002_01_first_Next.up.sql":    {Data: []byte("CREATE TABLE first_others (id INTEGER);\n")},
+		"0002_01_first_Next.down.sql":  {Data: []byte("DROP TABLE first_others;\n")},
+	}
+	db := openSQLite(t)
+	m, err := NewMigratorFS(fsys, []string{"first"}, false)
+	assert.Nil(t, err)
+	m.Backend = SQLite
+	err = m.Goto("first", 1, db)
+	assert.Nil(t, err)
+
+	m.TableName, err = TableNameTemplate("{schema}_versions")
+	assert.Nil(t, err)
+	tableExists := func(table string) bool {
+		exists, err := SQLite.TableExists(context.Background(), db, table)
+		assert.Nil(t, err)
+		return exists
+	}
+
+	// Reading the status doesn't adopt the legacy table, but reads it
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), statuses[0].Version)
+	assert.True(t, tableExists(LegacyTableName("first")))
+	assert.False(t, tableExists("first_versions"))
+
+	// Changing the database adopts it, keeping it under another name
+	err = m.Up("first", db)
+	assert.Nil(t, err)
+	statuses, err = m.Status(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), statuses[0].Version)
+	assert.False(t, tableExists(LegacyTableName("first")))
+	assert.True(t, tableExists(LegacyTableName("first")+adoptedSuffix))
+
+	// It isn't adopted again once every migration is reverted
+	err = m.Down("", db)
+	assert.Nil(t, err)
+	statuses, err = m.Status(db)
+	assert.Nil(t, err)
+	assert.False(t, statuses[0].Applied)
+
+	m.TableName, err = TableNameTemplate("migrations.{schema}")
+	assert.Nil(t, err)
+	err = m.Up("first", db)
+	assert.ErrorIs(t, err, ErrQualifiedTable)
+}
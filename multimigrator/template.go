@@ -0,0 +1,34 @@
+package multimigrator
+
+import (
+	"os"
+	"strings"
+
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+)
+
+// Migration files named like 0001_01_billing_Start.up.sql.tmpl are rendered
+// with text/template before they run, with Migrator.TemplateVars as the data,
+// so that {{.role_name}} is replaced by the role_name variable. A template
+// that refers to a variable that isn't set fails with ErrTemplate before any
+// migration runs.
+//
+// New sets the variables from the template_vars map in order.yaml, then from
+// environment variables named TemplateVarEnvPrefix followed by the variable's
+// name, then from WithTemplateVars, each overriding the ones before.
+const TemplateVarEnvPrefix = "MULTIMIGRATOR_VAR_"
+
+var ErrTemplate = schematadriver.ErrTemplate
+
+// templateVarsFromEnv returns the template variables set in the environment.
+func templateVarsFromEnv() map[string]string {
+
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(k, TemplateVarEnvPrefix); ok && name != "" {
+			vars[name] = v
+		}
+	}
+	return vars
+}
@@ -0,0 +1,109 @@
+package multimigrator
+
+import (
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestUp_Templates(t *testing.T) {
+
+	type testCase struct {
+		name  string
+		order string
+		env   map[string]string
+		vars  map[string]string
+		table string
+		err   error
+	}
+	tcs := []testCase{
+		{
+			name:  "Variable from order.yaml",
+			order: "schema_ordering: [first]\ntemplate_vars: {table: from_order}\n",
+			table: "from_order",
+		},
+		{
+			name:  "Environment overrides order.yaml",
+			order: "schema_ordering: [first]\ntemplate_vars: {table: from_order}\n",
+			env:   map[string]string{TemplateVarEnvPrefix + "table": "from_env"},
+			table: "from_env",
+		},
+		{
+			name:  "Option overrides the environment",
+			order: "schema_ordering: [first]\n",
+			env:   map[string]string{TemplateVarEnvPrefix + "table": "from_env"},
+			vars:  map[string]string{"table": "from_option"},
+			table: "from_option",
+		},
+		{
+			name:  "Undefined variable",
+			order: "schema_ordering: [first]\n",
+			err:   ErrTemplate,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			fsys := testFS([]string{"first"}, map[string]string{
+				"0001_01_first_Start.up.sql.tmpl": "CREATE TABLE {{.table}} (id INTEGER);\n",
+			})
+			fsys["order.yaml"] = &fstest.MapFile{Data: []byte(tc.order)}
+			m := sqliteMigrator(t, fsys, WithTemplateVars(tc.vars))
+			db := openSQLite(t)
+			err := m.Up("first", db)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			var name string
+			err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, tc.table).Scan(&name)
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestValidate_Templates(t *testing.T) {
+
+	fsys := testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql.tmpl":   "CREATE TABLE {{.table}} (id INTEGER);\n",
+		"0001_01_first_Start.down.sql.tmpl": "DROP TABLE {{.table}};\n",
+	})
+	m, err := New(WithFS(fsys))
+	assert.Nil(t, err)
+	issues, err := m.Validate()
+	assert.Nil(t, err)
+	assert.Len(t, issues, 2)
+	for _, vi := range issues {
+		assert.Equal(t, IssueInvalidTemplate, vi.Kind)
+	}
+
+	m, err = New(WithFS(fsys), WithTemplateVars(map[string]string{"table": "t"}))
+	assert.Nil(t, err)
+	issues, err = m.Validate()
+	assert.Nil(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestUp_TemplateVarsArentDrift(t *testing.T) {
+
+	fsys := testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql.tmpl": "CREATE TABLE {{.table}} (id INTEGER);\n",
+	})
+	db := openSQLite(t)
+	assert.Nil(t, sqliteMigrator(t, fsys, WithTemplateVars(map[string]string{"table": "before"})).Up("first", db))
+
+	m := sqliteMigrator(t, fsys, WithTemplateVars(map[string]string{"table": "after"}))
+	statuses, err := m.Status(db)
+	assert.Nil(t, err)
+	assert.Empty(t, statuses[0].Drifted)
+	assert.Nil(t, m.Up("first", db))
+
+	// Editing the template itself still is
+	fsys["0001_01_first_Start.up.sql.tmpl"] = &fstest.MapFile{Data: []byte("CREATE TABLE {{.table}} (id BIGINT);\n")}
+	m = sqliteMigrator(t, fsys, WithTemplateVars(map[string]string{"table": "after"}))
+	assert.ErrorIs(t, m.Up("first", db), ErrChecksumMismatch)
+}
@@ -0,0 +1,371 @@
+package multimigrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// TransactionMode is how Up groups migrations into transactions.
+type TransactionMode string
+
+const (
+	// NoTransactions runs each migration file by itself, as golang-migrate does,
+	// so a failure leaves the earlier migrations applied and its schema dirty.
+	NoTransactions TransactionMode = ""
+	// TransactionPerRun runs every migration of an Up in one transaction, so a
+	// failure rolls every schema back to where it started.
+	TransactionPerRun TransactionMode = "run"
+	// TransactionPerVersion runs the migrations of every schema for each
+	// version in one transaction, so a failure rolls back only that version.
+	TransactionPerVersion TransactionMode = "version"
+)
+
+var ErrTransactionsUnsupported = errors.New("backend can't roll back schema changes")
+
+// checkTransactions fails if Up can't run in the configured TransactionMode.
+func (m *Migrator) checkTransactions() error {
+
+	switch m.Transactions {
+	case NoTransactions:
+		return nil
+	case TransactionPerRun, TransactionPerVersion:
+	default:
+		return fmt.Errorf("unknown transaction mode %q", m.Transactions)
+	}
+	if !m.backend().Dialect().TransactionalDDL {
+		return fmt.Errorf("for transaction mode %q: %w", m.Transactions, ErrTransactionsUnsupported)
+	}
+	return nil
+}
+
+// batch runs planned migrations inside transactions on one connection.
+// Migrations write the migrations table themselves within the transaction,
+// so a rolled back migration leaves no dirty version behind.
+type batch struct {
+	mp      migratorParts
+	db      *sql.DB
+	dialect Dialect
+	mode    TransactionMode
+	logger  *slog.Logger
+	tx      *sql.Tx
+	// done are the migrations run in tx, whose history and checksums are
+	// recorded once it commits
+	done []batchStep
+	// repeatableCount is the number of repeatable migrations committed
+	repeatableCount int
+}
+
+type batchStep struct {
+	step  plannedStep
+	entry HistoryEntry
+	// repeatable is set for a repeatable migration, which ran once step's part
+	// was at step's version
+	repeatable *repeatable
+}
+
+// applyInTransactions applies the out of order steps late, then the steps and
+// repeatable migrations applyMigrations would run, inside transactions grouped
+// by mode. Migrations that opt out of transactions commit the transaction so
+// far and run by themselves.
+func (mp migratorParts) applyInTransactions(ctx context.Context, logger *slog.Logger, db *sql.DB, dialect Dialect, mode TransactionMode, late []plannedStep) error {
+
+	// The migrations table is written directly, which would hide a dirty flag
+	for _, p := range mp {
+		version, dirty, err := p.instance.Version()
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("for schema %s: %w", p.schema, migrate.ErrDirty{Version: int(version)})
+		}
+	}
+	steps, err := mp.planMigrations(math.MaxUint)
+	if err != nil {
+		return err
+	}
+	b := &batch{mp: mp, db: db, dialect: dialect, mode: mode, logger: logger}
+	err = b.apply(ctx, late, steps)
+	if err != nil {
+		return errors.Join(err, b.rollback(ctx))
+	}
+	err = b.commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Applied migrations", "applied", len(late)+len(steps))
+	if mp.hasRepeatables() {
+		logger.Info("Applied repeatable migrations", "applied", b.repeatableCount)
+	}
+	return nil
+}
+
+// apply runs the out of order steps late, then steps, running each part's
+// repeatable migrations once its schema level is done.
+func (b *batch) apply(ctx context.Context, late, steps []plannedStep) error {
+
+	all := append(slices.Clone(late), steps...)
+	done := b.mp.levelsDone(steps)
+	for i, s := range all {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// The levels done before the kth of steps are done after the one before
+		if k := i - len(late); k >= 0 {
+			err := b.applyRepeatables(ctx, steps, done[k])
+			if err != nil {
+				return err
+			}
+		}
+		if b.mode == TransactionPerVersion && i > 0 && s.version != all[i-1].version {
+			err := b.commit(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		p := b.mp[s.index]
+		d, err := p.sourceDrv.Directives(s.version, source.Up)
+		if err != nil {
+			return err
+		}
+		if d.NoTransaction {
+			err = b.commit(ctx)
+			if err != nil {
+				return err
+			}
+			if s.outOfOrder {
+				err = p.applyAt(ctx, b.logger, s.version)
+			} else {
+				err = p.steps(ctx, b.logger, 1)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = b.begin(ctx)
+		if err != nil {
+			return err
+		}
+		step := StepInfo{Schema: p.schema, Version: s.version, Direction: source.Up}
+		if mig, err := p.sourceDrv.Migration(s.version, source.Up); err == nil {
+			step.Identifier = mig.Identifier
+		}
+		entry, err := p.runStep(ctx, b.logger, step, func() error {
+			return p.withDirectives(ctx, b.tx, nil, step, func(timeouts string) error {
+				return b.run(ctx, p, s, timeouts)
+			})
+		})
+		if errors.Is(err, ErrStepVetoed) {
+			return err
+		}
+		b.done = append(b.done, batchStep{step: s, entry: entry})
+		if err != nil {
+			return err
+		}
+	}
+	return b.applyRepeatables(ctx, steps, done[len(steps)])
+}
+
+// begin begins a transaction unless one is open.
+func (b *batch) begin(ctx context.Context) error {
+
+	if b.tx != nil {
+		return nil
+	}
+	var err error
+	b.tx, err = b.db.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return fmt.Errorf("while beginning transaction: %w", err)
+	}
+	return nil
+}
+
+// applyRepeatables runs the changed repeatable migrations of the parts at the
+// given indices in the transaction, at the versions steps bring them to.
+func (b *batch) applyRepeatables(ctx context.Context, steps []plannedStep, indices []int) error {
+
+	for _, index := range indices {
+		p := b.mp[index]
+		if len(p.repeatables) == 0 {
+			continue
+		}
+		version, ok, err := b.mp.versionAfter(index, steps)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		changed, err := p.changedRepeatables(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range changed {
+			r := &changed[i]
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err = b.begin(ctx)
+			if err != nil {
+				return err
+			}
+			step := StepInfo{Schema: p.schema, Version: version, Identifier: r.name, Direction: source.Up}
+			entry, err := p.runStep(ctx, b.logger, step, func() error {
+				ctx := context.WithoutCancel(ctx)
+				if timeouts := p.localTimeouts(0, 0); timeouts != "" {
+					_, err := b.tx.ExecContext(ctx, timeouts)
+					if err != nil {
+						return fmt.Errorf("while setting timeouts: %w", err)
+					}
+				}
+				_, err := b.tx.ExecContext(ctx, string(r.body))
+				return err
+			})
+			if errors.Is(err, ErrStepVetoed) {
+				return err
+			}
+			b.done = append(b.done, batchStep{step: plannedStep{index: index, version: version}, entry: entry, repeatable: r})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run runs a migration in the transaction after setting its timeouts, and,
+// unless it's out of order, records its version.
+func (b *batch) run(ctx context.Context, p *migratorPart, s plannedStep, timeouts string) error {
+
+	ctx = context.WithoutCancel(ctx)
+	body, err := p.readUp(s.version)
+	if err != nil {
+		return err
+	}
+	if timeouts != "" {
+		_, err = b.tx.ExecContext(ctx, timeouts)
+		if err != nil {
+			return fmt.Errorf("while setting timeouts: %w", err)
+		}
+	}
+	if p.goRunner.has(s.version) {
+		gm := p.goRunner.migrations[s.version]
+		err := gm.up(ctx, b.tx)
+		if err != nil {
+			return fmt.Errorf("in Go migration %s: %w", gm.identifier, err)
+		}
+	} else if strings.TrimSpace(body) != "" {
+		_, err := b.tx.ExecContext(ctx, body)
+		if err != nil {
+			return fmt.Errorf("while running version %d: %w", s.version, err)
+		}
+	}
+	if s.outOfOrder {
+		return nil
+	}
+	err = writeVersion(ctx, b.tx, b.dialect, p.table, int(s.version), false)
+	if err != nil {
+		return fmt.Errorf("while setting version %d: %w", s.version, err)
+	}
+	return nil
+}
+
+// commit commits the open transaction, if any, then records the history and
+// checksums of the migrations it ran.
+func (b *batch) commit(ctx context.Context) error {
+
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Commit()
+	b.tx = nil
+	if err != nil {
+		return fmt.Errorf("while committing transaction: %w", err)
+	}
+	done := b.done
+	b.done = nil
+	for _, d := range done {
+		p := b.mp[d.step.index]
+		err = p.finishStep(ctx, d.entry, nil)
+		if err != nil {
+			return err
+		}
+		if d.repeatable != nil {
+			err = p.repeatableStore.record(ctx, p.schema, d.repeatable.name, d.repeatable.checksum)
+			if err != nil {
+				return fmt.Errorf("while recording checksum of %s for schema %s: %w", d.repeatable.name, p.schema, err)
+			}
+			b.repeatableCount++
+			continue
+		}
+		if p.checksums == nil {
+			continue
+		}
+		sum, err := p.checksum(d.step.version)
+		if err != nil {
+			return err
+		}
+		err = p.checksums.record(ctx, p.schema, d.step.version, sum)
+		if err != nil {
+			return fmt.Errorf("while recording checksum of version %d for schema %s: %w", d.step.version, p.schema, err)
+		}
+	}
+	return nil
+}
+
+// rollback rolls back the open transaction, if any, and records the failed
+// migration in the history. The migrations that succeeded before it are
+// logged but not recorded, since they were rolled back too.
+func (b *batch) rollback(ctx context.Context) error {
+
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Rollback()
+	b.tx = nil
+	if err != nil {
+		return fmt.Errorf("while rolling back transaction: %w", err)
+	}
+	done := b.done
+	b.done = nil
+	rolledBack := 0
+	for _, d := range done {
+		if d.entry.Success {
+			rolledBack++
+		}
+	}
+	if rolledBack > 0 {
+		b.logger.Warn("Rolled back migrations", "rolled_back", rolledBack)
+	}
+	if len(done) == 0 || done[len(done)-1].entry.Success {
+		return nil
+	}
+	last := done[len(done)-1]
+	return b.mp[last.step.index].finishStep(ctx, last.entry, nil)
+}
+
+// readUp reads the body of the up migration for version.
+func (p *migratorPart) readUp(version uint) (string, error) {
+
+	r, _, err := p.sourceDrv.ReadUp(version)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
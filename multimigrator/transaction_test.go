@@ -0,0 +1,119 @@
+package multimigrator
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func transactionFS(failing string) fstest.MapFS {
+	return testFS([]string{"shelves", "books"}, map[string]string{
+		"0001_01_shelves_Start.up.sql":   "CREATE TABLE shelves (id INTEGER);\n",
+		"0001_02_books_Start.up.sql":     "CREATE TABLE books (id INTEGER);\n",
+		"0002_01_shelves_AddName.up.sql": "ALTER TABLE shelves ADD COLUMN name TEXT;\n",
+		"0002_02_books_AddName.up.sql":   failing,
+	})
+}
+
+func TestUp_Transactions(t *testing.T) {
+
+	type testCase struct {
+		name string
+		mode TransactionMode
+		// failing is the body of the last migration
+		failing string
+		// expected are the versions of each schema after Up
+		expected []uint
+		applied  []bool
+		// history is the number of history entries after Up
+		history int
+		err     bool
+	}
+	tcs := []testCase{
+		{
+			name:     "A failure rolls back the whole run",
+			mode:     TransactionPerRun,
+			failing:  "ALTER TABLE nope ADD COLUMN name TEXT;\n",
+			expected: []uint{0, 0},
+			applied:  []bool{false, false},
+			history:  1,
+			err:      true,
+		},
+		{
+			name:     "A failure rolls back only its version",
+			mode:     TransactionPerVersion,
+			failing:  "ALTER TABLE nope ADD COLUMN name TEXT;\n",
+			expected: []uint{1, 1},
+			applied:  []bool{true, true},
+			history:  3,
+			err:      true,
+		},
+		{
+			name:     "A file can opt out of the transaction",
+			mode:     TransactionPerRun,
+			failing:  "-- multimigrator:no-transaction\nALTER TABLE books ADD COLUMN name TEXT;\n",
+			expected: []uint{2, 2},
+			applied:  []bool{true, true},
+			history:  4,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			m := sqliteMigrator(t, transactionFS(tc.failing), WithTransactions(tc.mode))
+			m.RecordHistory = true
+			db := openSQLite(t)
+
+			err := m.Up("books", db)
+			if tc.err {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			statuses, err := m.Status(db)
+			assert.Nil(t, err)
+			for i, s := range statuses {
+				assert.Equal(t, tc.applied[i], s.Applied, s.Schema)
+				assert.Equal(t, tc.expected[i], s.Version, s.Schema)
+				assert.False(t, s.Dirty, s.Schema)
+			}
+			// Rolled back migrations aren't in the history, but the failed one is
+			entries, err := m.History(db, HistoryFilter{})
+			assert.Nil(t, err)
+			assert.Len(t, entries, tc.history)
+			assert.Equal(t, !tc.err, entries[0].Success)
+		})
+	}
+}
+
+func TestUp_TransactionsUnsupported(t *testing.T) {
+
+	m, err := New(WithFS(transactionFS("")), WithBackend(MySQL), WithTransactions(TransactionPerRun))
+	assert.Nil(t, err)
+	err = m.UpContext(context.Background(), "books", nil)
+	assert.ErrorIs(t, err, ErrTransactionsUnsupported)
+}
+
+func TestUp_TransactionsRepeatables(t *testing.T) {
+
+	fsys := transactionFS("ALTER TABLE books ADD COLUMN name TEXT;\n")
+	fsys["R_01_shelves_Views.sql"] = &fstest.MapFile{Data: []byte("CREATE VIEW shelf_names AS SELEC name FROM shelves;\n")}
+	db := openSQLite(t)
+
+	// A failing repeatable migration rolls back the run's versioned migrations
+	err := sqliteMigrator(t, fsys, WithTransactions(TransactionPerRun)).Up("books", db)
+	assert.NotNil(t, err)
+	statuses, err := sqliteMigrator(t, fsys).Status(db)
+	assert.Nil(t, err)
+	for _, s := range statuses {
+		assert.False(t, s.Applied, s.Schema)
+	}
+
+	fsys["R_01_shelves_Views.sql"] = &fstest.MapFile{Data: []byte("CREATE VIEW shelf_names AS SELECT name FROM shelves;\n")}
+	assert.Nil(t, sqliteMigrator(t, fsys, WithTransactions(TransactionPerRun)).Up("books", db))
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM `+RepeatableTable+` WHERE schema_name = 'shelves'`).Scan(&count))
+	assert.Equal(t, 1, count)
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM shelf_names`).Scan(&count))
+}
@@ -0,0 +1,339 @@
+package multimigrator
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alexrjones/multimigrator/internal/schematadriver"
+)
+
+// Severity is how serious a ValidationIssue is. Only errors stop migrations
+// from running correctly.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// IssueKind identifies the kind of problem a ValidationIssue describes.
+type IssueKind string
+
+const (
+	// IssueUnmatchedFile is a .sql file that isn't named like a migration.
+	IssueUnmatchedFile IssueKind = "unmatched_file"
+	// IssueUnknownSchema is a migration file for a schema not in the ordering.
+	IssueUnknownSchema IssueKind = "unknown_schema"
+	// IssueEmptySchema is a schema in the ordering without migration files or
+	// registered Go migrations.
+	IssueEmptySchema IssueKind = "empty_schema"
+	// IssueMissingPair is a migration version with an up or down file, but not both.
+	IssueMissingPair IssueKind = "missing_pair"
+	// IssueDuplicateVersion is a version used by more than one file in a schema.
+	IssueDuplicateVersion IssueKind = "duplicate_version"
+	// IssueSchemaIndex is a schema index segment that differs between the files
+	// of a schema, or is shared with another schema.
+	IssueSchemaIndex IssueKind = "schema_index"
+	// IssueInvalidDirective is a directive that can't be parsed, or that
+	// requires a schema not in the ordering.
+	IssueInvalidDirective IssueKind = "invalid_directive"
+	// IssueInvalidTemplate is a template file that can't be rendered with the
+	// template variables.
+	IssueInvalidTemplate IssueKind = "invalid_template"
+	// IssueNestedFile is a migration file in a subdirectory, which can't be
+	// loaded since migrations are read from the root of the migrations.
+	IssueNestedFile IssueKind = "nested_file"
+)
+
+// ValidationIssue is a problem found in the migrations directory.
+type ValidationIssue struct {
+	Severity Severity  `json:"severity"`
+	Kind     IssueKind `json:"kind"`
+	Schema   string    `json:"schema,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Message  string    `json:"message"`
+}
+
+func (vi ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s", vi.Severity, vi.Message)
+}
+
+// HasErrors reports whether any of the issues is an error rather than a warning.
+func HasErrors(issues []ValidationIssue) bool {
+	return slices.ContainsFunc(issues, func(vi ValidationIssue) bool {
+		return vi.Severity == SeverityError
+	})
+}
+
+// migrationNameRegex matches the parts of a migration file name like
+// (0001)_(01)_(first_Start).(up).sql, where the schema name and identifier
+// can't be told apart without knowing the schema names.
+var migrationNameRegex = regexp.MustCompile(`^(\d+)_(\d+)_([^.]+)\.(up|down)\.sql(?:\.tmpl)?$`)
+
+// repeatableNameRegex matches a repeatable migration file name like
+// R_(01)_(first_Views).sql.
+var repeatableNameRegex = regexp.MustCompile(`^R_(\d+)_([^.]+)\.sql(?:\.tmpl)?$`)
+
+// Validate lints the migration files, reporting files that don't belong to any
+// schema or are in subdirectories, schemata without migrations, versions
+// without both an up and a down file, versions used twice in a schema and
+// inconsistent schema index segments.
+func (m *Migrator) Validate() ([]ValidationIssue, error) {
+
+	issues := make([]ValidationIssue, 0)
+	// paths maps the name of each loaded file to its path
+	paths := make(map[string]string)
+	owners := make(map[string]string)
+	for schema, names := range m.paths {
+		for _, n := range names {
+			owners[n] = schema
+		}
+	}
+	for schema, names := range m.repeatables {
+		for _, n := range names {
+			owners[n] = schema
+		}
+	}
+
+	err := fs.WalkDir(m.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if schema, ok := owners[d.Name()]; ok {
+			if _, ok := paths[d.Name()]; !ok || path == d.Name() {
+				paths[d.Name()] = path
+			}
+			if path != d.Name() {
+				issues = append(issues, ValidationIssue{
+					Severity: SeverityError,
+					Kind:     IssueNestedFile,
+					Schema:   schema,
+					File:     path,
+					Message:  fmt.Sprintf("%s is in a subdirectory, but migrations are only read from the root of the migrations", path),
+				})
+				return nil
+			}
+			// The driver loads the file, so it must parse like a migration
+			if !migrationNameRegex.MatchString(d.Name()) && !slices.Contains(m.repeatables[schema], d.Name()) {
+				issues = append(issues, ValidationIssue{
+					Severity: SeverityError,
+					Kind:     IssueUnmatchedFile,
+					Schema:   schema,
+					File:     path,
+					Message:  fmt.Sprintf("%s is loaded for schema %s, but isn't named like <version>_<index>_<schema>_<name>.(up|down).sql", path, schema),
+				})
+			}
+			return nil
+		}
+		if !strings.HasSuffix(strings.TrimSuffix(d.Name(), schematadriver.TemplateSuffix), ".sql") {
+			return nil
+		}
+		// Repeatables are only found at the root, so a nested one has no schema
+		if path != d.Name() && repeatableNameRegex.MatchString(d.Name()) {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Kind:     IssueNestedFile,
+				File:     path,
+				Message:  fmt.Sprintf("%s is in a subdirectory, but migrations are only read from the root of the migrations", path),
+			})
+			return nil
+		}
+		if migrationNameRegex.MatchString(d.Name()) {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Kind:     IssueUnknownSchema,
+				File:     path,
+				Message:  fmt.Sprintf("%s doesn't belong to any schema in the ordering", path),
+			})
+			return nil
+		}
+		issues = append(issues, ValidationIssue{
+			Severity: SeverityError,
+			Kind:     IssueUnmatchedFile,
+			File:     path,
+			Message:  fmt.Sprintf("%s isn't named like <version>_<index>_<schema>_<name>.(up|down).sql or R_<index>_<schema>_<name>.sql", path),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexOwners := make(map[uint64]string)
+	for _, schema := range m.Schemata {
+		names := m.paths[schema]
+		if len(names) == 0 && len(registeredGoMigrations(schema)) == 0 {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Kind:     IssueEmptySchema,
+				Schema:   schema,
+				Message:  fmt.Sprintf("schema %s has no migration files", schema),
+			})
+			continue
+		}
+		issues = append(issues, validateSchema(schema, names, indexOwners)...)
+	}
+	directiveIssues, err := m.validateDirectives(paths)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, directiveIssues...)
+
+	return issues, nil
+}
+
+// validateDirectives renders every template and parses the directives of
+// every migration file, reading each from its path in paths.
+func (m *Migrator) validateDirectives(paths map[string]string) ([]ValidationIssue, error) {
+
+	issues := make([]ValidationIssue, 0)
+	for _, schema := range m.Schemata {
+		for _, n := range m.paths[schema] {
+			path := paths[n]
+			body, err := fs.ReadFile(m.fsys, path)
+			if err != nil {
+				return nil, err
+			}
+			if schematadriver.IsTemplate(n) {
+				body, err = schematadriver.RenderTemplate(n, body, m.TemplateVars)
+				if err != nil {
+					issues = append(issues, ValidationIssue{
+						Severity: SeverityError,
+						Kind:     IssueInvalidTemplate,
+						Schema:   schema,
+						File:     path,
+						Message:  err.Error(),
+					})
+					continue
+				}
+			}
+			d, err := schematadriver.ParseDirectives(bytes.NewReader(body))
+			if err != nil {
+				issues = append(issues, ValidationIssue{
+					Severity: SeverityError,
+					Kind:     IssueInvalidDirective,
+					Schema:   schema,
+					File:     path,
+					Message:  fmt.Sprintf("%s: %v", path, err),
+				})
+				continue
+			}
+			for _, r := range d.Requires {
+				if _, ok := findSchema(r.Schema, m.Schemata); !ok {
+					issues = append(issues, ValidationIssue{
+						Severity: SeverityError,
+						Kind:     IssueInvalidDirective,
+						Schema:   schema,
+						File:     path,
+						Message:  fmt.Sprintf("%s requires %s, but schema %s isn't in the ordering", path, r, r.Schema),
+					})
+				}
+			}
+		}
+	}
+	return issues, nil
+}
+
+func validateSchema(schema string, names []string, indexOwners map[uint64]string) []ValidationIssue {
+
+	type versionFiles struct {
+		up   []string
+		down []string
+	}
+	issues := make([]ValidationIssue, 0)
+	versions := make(map[uint64]*versionFiles)
+	versionOrder := make([]uint64, 0)
+	var schemaIndex uint64
+	var indexFile string
+	for _, n := range names {
+		parts := migrationNameRegex.FindStringSubmatch(n)
+		if parts == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		index, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		if indexFile == "" {
+			schemaIndex, indexFile = index, n
+			if other, ok := indexOwners[index]; ok && other != schema {
+				issues = append(issues, ValidationIssue{
+					Severity: SeverityError,
+					Kind:     IssueSchemaIndex,
+					Schema:   schema,
+					File:     n,
+					Message:  fmt.Sprintf("schema %s uses index %s, which is already used by schema %s", schema, parts[2], other),
+				})
+			} else {
+				indexOwners[index] = schema
+			}
+		} else if index != schemaIndex {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Kind:     IssueSchemaIndex,
+				Schema:   schema,
+				File:     n,
+				Message:  fmt.Sprintf("%s has schema index %s, but %s has index %d", n, parts[2], indexFile, schemaIndex),
+			})
+		}
+		vf, ok := versions[version]
+		if !ok {
+			vf = &versionFiles{}
+			versions[version] = vf
+			versionOrder = append(versionOrder, version)
+		}
+		if parts[4] == "up" {
+			vf.up = append(vf.up, n)
+		} else {
+			vf.down = append(vf.down, n)
+		}
+	}
+
+	slices.Sort(versionOrder)
+	for _, v := range versionOrder {
+		vf := versions[v]
+		for _, files := range [][]string{vf.up, vf.down} {
+			if len(files) > 1 {
+				issues = append(issues, ValidationIssue{
+					Severity: SeverityError,
+					Kind:     IssueDuplicateVersion,
+					Schema:   schema,
+					File:     files[1],
+					Message:  fmt.Sprintf("schema %s has more than one file for version %d: %s", schema, v, strings.Join(files, ", ")),
+				})
+			}
+		}
+		if len(vf.up) == 0 {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityWarning,
+				Kind:     IssueMissingPair,
+				Schema:   schema,
+				File:     vf.down[0],
+				Message:  fmt.Sprintf("%s has no matching up migration", vf.down[0]),
+			})
+		} else if len(vf.down) == 0 {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityWarning,
+				Kind:     IssueMissingPair,
+				Schema:   schema,
+				File:     vf.up[0],
+				Message:  fmt.Sprintf("%s has no matching down migration", vf.up[0]),
+			})
+		}
+	}
+
+	return issues
+}
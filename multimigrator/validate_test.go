@@ -0,0 +1,90 @@
+package multimigrator
+
+import (
+	"testing"
+	"testing/fstest"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"order.yaml":                     {Data: []byte("schema_ordering: [first, second, third, fourth]\n")},
+		"README.md":                      {Data: []byte("# Migrations\n")},
+		"0001_01_first_Start.up.sql":     {},
+		"0001_01_first_Start.down.sql":   {},
+		"0002_01_first_Amend.up.sql":     {},
+		"0002_01_first_Again.up.sql":     {},
+		"0002_01_first_Amend.down.sql":   {},
+		"0001_02_second_Start.up.sql":    {},
+		"0001_02_second_Start.down.sql":  {},
+		"0002_03_second_Amend.up.sql":    {},
+		"0002_03_second_Amend.down.sql":  {},
+		"0001_02_third_Start.up.sql":     {},
+		"0001_05_unknown_Start.up.sql":   {},
+		"0001-01-first-Start.up.sql":     {},
+		"nested/0003_01_first_X.up.txt":  {},
+		"0001_01_first_Start.up.sql.bak": {},
+		"x0003_01_first_Extra.up.sql":    {},
+	}
+	m, err := NewMigratorFS(fsys, nil, false)
+	assert.Nil(t, err)
+
+	issues, err := m.Validate()
+	assert.Nil(t, err)
+	assert.True(t, HasErrors(issues))
+
+	kinds := make(map[IssueKind][]string)
+	for _, vi := range issues {
+		kinds[vi.Kind] = append(kinds[vi.Kind], vi.Schema+":"+vi.File)
+	}
+	assert.Equal(t, map[IssueKind][]string{
+		IssueUnmatchedFile:    {":0001-01-first-Start.up.sql", "first:x0003_01_first_Extra.up.sql"},
+		IssueUnknownSchema:    {":0001_05_unknown_Start.up.sql"},
+		IssueEmptySchema:      {"fourth:"},
+		IssueDuplicateVersion: {"first:0002_01_first_Amend.up.sql"},
+		IssueSchemaIndex: {
+			"second:0002_03_second_Amend.down.sql",
+			"second:0002_03_second_Amend.up.sql",
+			"third:0001_02_third_Start.up.sql",
+		},
+		IssueMissingPair: {"third:0001_02_third_Start.up.sql"},
+	}, kinds)
+}
+
+func TestValidate_MatchesDriver(t *testing.T) {
+
+	m := sqliteMigrator(t, testFS([]string{"first"}, map[string]string{
+		"0001_01_first_Start.up.sql":     "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_01_first_Start.down.sql":   "DROP TABLE first_items;\n",
+		"0001_01_first_Start.up.sql.bak": "CREATE TABLE first_old (id INTEGER);\n",
+	}))
+	issues, err := m.Validate()
+	assert.Nil(t, err)
+	assert.Empty(t, issues)
+	assert.Nil(t, m.Up("first", openSQLite(t)))
+}
+
+func TestValidate_NestedAndGoMigrations(t *testing.T) {
+
+	// gadgets only has the Go migration registered in gomigration_test.go
+	fsys := testFS([]string{"gadgets", "first"}, map[string]string{
+		"0001_02_first_Start.up.sql":          "CREATE TABLE first_items (id INTEGER);\n",
+		"0001_02_first_Start.down.sql":        "DROP TABLE first_items;\n",
+		"nested/0002_02_first_Amend.up.sql":   "-- multimigrator:timeout=1m\n",
+		"nested/0002_02_first_Amend.down.sql": "",
+		"nested/R_02_first_Views.sql":         "CREATE VIEW first_view AS SELECT 1;\n",
+	})
+	m, err := NewMigratorFS(fsys, nil, false)
+	assert.Nil(t, err)
+
+	issues, err := m.Validate()
+	assert.Nil(t, err)
+	files := make([]string, 0)
+	for _, vi := range issues {
+		assert.Equal(t, IssueNestedFile, vi.Kind)
+		files = append(files, vi.File)
+	}
+	assert.Equal(t, []string{"nested/0002_02_first_Amend.down.sql", "nested/0002_02_first_Amend.up.sql", "nested/R_02_first_Views.sql"}, files)
+}
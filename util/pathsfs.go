@@ -4,17 +4,19 @@ import (
 	"errors"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 )
 
 var ErrInvalidRoot = errors.New("invalid root directory")
 
 type pathsFS struct {
-	root       string
+	fsys       fs.FS
 	names      map[string]fs.DirEntry
 	namesSlice []fs.DirEntry
 }
 
+// PathsFS returns a filesystem rooted at root that only exposes the given paths.
 func PathsFS(root string, paths []string) (fs.ReadDirFS, error) {
 
 	if root == "" {
@@ -28,25 +30,33 @@ func PathsFS(root string, paths []string) (fs.ReadDirFS, error) {
 			return nil, err
 		}
 	}
+
+	return NewPathsFS(os.DirFS(canonicalRoot), paths)
+}
+
+// NewPathsFS is like PathsFS, but exposes paths from fsys rather than from a
+// directory on disk. Paths are relative to the root of fsys.
+func NewPathsFS(fsys fs.FS, paths []string) (fs.ReadDirFS, error) {
+
 	names := make(map[string]fs.DirEntry)
 	namesSlice := make([]fs.DirEntry, 0)
 	for _, p := range paths {
-		path := filepath.Join(canonicalRoot, p)
-		stat, err := os.Stat(path)
+		p = path.Clean(filepath.ToSlash(p))
+		stat, err := fs.Stat(fsys, p)
 		if err != nil {
 			return nil, err
 		}
 		finfo := &finfoWrapper{stat}
-		names[path] = finfo
+		names[p] = finfo
 		namesSlice = append(namesSlice, finfo)
 	}
 
-	return &pathsFS{root: canonicalRoot, names: names, namesSlice: namesSlice}, nil
+	return &pathsFS{fsys: fsys, names: names, namesSlice: namesSlice}, nil
 }
 
 // implements [fs.DirEntry]
 type finfoWrapper struct {
-	os.FileInfo
+	fs.FileInfo
 }
 
 func (f *finfoWrapper) Info() (fs.FileInfo, error) {
@@ -71,7 +81,7 @@ func (p *pathsFS) Open(name string) (fs.File, error) {
 		return nil, err
 	}
 
-	return os.Open(name)
+	return p.fsys.Open(name)
 }
 
 // ReadDir reads the named directory
@@ -84,15 +94,15 @@ func (p *pathsFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	}
 
 	// If reading the root directory itself, don't delegate to
-	// os.ReadDir, because that might return files the user has
-	// deliberately filtered out. Instead return the names we
-	// collected during initialisation.
-	if name == p.root {
+	// the underlying filesystem, because that might return files
+	// the user has deliberately filtered out. Instead return the
+	// names we collected during initialisation.
+	if name == "." {
 		return p.namesSlice, nil
 	}
 
 	// We'll allow reading all files in any child directories though.
-	return os.ReadDir(name)
+	return fs.ReadDir(p.fsys, name)
 }
 
 func (p *pathsFS) validate(name string) (string, error) {
@@ -104,18 +114,10 @@ func (p *pathsFS) validate(name string) (string, error) {
 		}
 	}
 
-	if !filepath.IsAbs(name) {
-		var err error
-		name, err = filepath.Abs(filepath.Join(p.root, name))
-		if err != nil {
-			return "", err
-		}
-	}
-
 	if _, ok := p.names[name]; !ok {
 		// The root directory is not contained in the list of names,
 		// so need another check for it here
-		if name != p.root {
+		if name != "." {
 			return "", &fs.PathError{
 				Op:   "open",
 				Path: name,
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	assert "github.com/stretchr/testify/require"
 )
@@ -82,3 +83,28 @@ func TestPathsFS_ReadDir_Error(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.ErrorIs(t, err, os.ErrNotExist)
 }
+
+func TestNewPathsFS_MapFS(t *testing.T) {
+
+	mapFS := fstest.MapFS{
+		"1.txt": {Data: []byte("1\n")},
+		"2.txt": {Data: []byte("2\n")},
+	}
+	fs, err := NewPathsFS(mapFS, []string{"2.txt"})
+	assert.Nil(t, err)
+
+	dir, err := fs.ReadDir(".")
+	assert.Nil(t, err)
+	assert.Len(t, dir, 1)
+	assert.Equal(t, "2.txt", dir[0].Name())
+
+	f, err := fs.Open("2.txt")
+	assert.Nil(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", string(b))
+
+	_, err = fs.Open("1.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}